@@ -1,12 +1,17 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ocelotconsulting/go-ocelot/proxy/reverse"
 	"github.com/ocelotconsulting/go-ocelot/routes"
 	"github.com/ocelotconsulting/go-ocelot/types"
 )
@@ -17,7 +22,8 @@ type API interface {
 }
 
 type repoWrapper struct {
-	repo routes.Repository
+	repo      routes.Repository
+	upstreams reverse.Proxy
 }
 
 func echo(w http.ResponseWriter, r *http.Request) {
@@ -66,6 +72,10 @@ func (repo *repoWrapper) putRoute(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+	if err := routes.Validate(route); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 	repo.repo.UpdateRoute(route)
 }
 
@@ -107,17 +117,220 @@ func (repo *repoWrapper) getRoutes(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
+func (repo *repoWrapper) getUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/upstreams/")
+
+	var js []byte
+	var err error
+	upstreams := repo.upstreams.Upstreams()
+
+	if id == "" {
+		js, err = json.Marshal(upstreams)
+	} else if targets, ok := upstreams[id]; ok {
+		js, err = json.Marshal(targets)
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func (repo *repoWrapper) getBreakers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/breakers/")
+
+	var js []byte
+	var err error
+	breakers := repo.upstreams.Breakers()
+
+	if id == "" {
+		js, err = json.Marshal(breakers)
+	} else if breaker, ok := breakers[id]; ok {
+		js, err = json.Marshal(breaker)
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// credentialRequest creates or replaces a credential, Secret is the basic auth password or the secret part of
+// an API key, which is generated when missing
+type credentialRequest struct {
+	types.Credential
+	Secret string `json:"secret,omitempty"`
+}
+
+// credentialResponse returns a credential without its hash, and the whole API key when it was just created
+type credentialResponse struct {
+	types.Credential
+	Key string `json:"key,omitempty"`
+}
+
+func (repo *repoWrapper) credentials(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		repo.getCredentials(w, r)
+	case "POST", "PUT":
+		repo.putCredential(w, r)
+	case "DELETE":
+		repo.delCredential(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (repo *repoWrapper) getCredentials(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/credentials/")
+
+	var js []byte
+	var err error
+	credentials := repo.repo.Credentials()
+
+	if id == "" {
+		result := make(map[string]types.Credential, len(credentials))
+		for id, credential := range credentials {
+			credential.Hash = ""
+			result[id] = credential
+		}
+		js, err = json.Marshal(result)
+	} else if credential, ok := credentials[id]; ok {
+		credential.Hash = ""
+		js, err = json.Marshal(credential)
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func (repo *repoWrapper) putCredential(w http.ResponseWriter, r *http.Request) {
+	var req credentialRequest
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", 400)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	switch {
+	case req.ID == "":
+		http.Error(w, "Credential is missing an id", 400)
+		return
+	case req.Type == types.AuthAPIKey && strings.Contains(req.ID, "."):
+		http.Error(w, "API key ids cannot contain dots", 400)
+		return
+	case req.Type == types.AuthBasic && req.Secret == "":
+		http.Error(w, "Basic auth credentials need a secret", 400)
+		return
+	case req.Type != types.AuthAPIKey && req.Type != types.AuthBasic:
+		http.Error(w, fmt.Sprintf("Unknown credential type %s", req.Type), 400)
+		return
+	}
+	resp := credentialResponse{Credential: req.Credential}
+	if req.Type == types.AuthAPIKey {
+		if req.Secret == "" {
+			secret := make([]byte, 24)
+			if _, err := rand.Read(secret); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			req.Secret = hex.EncodeToString(secret)
+		}
+		resp.Key = req.ID + "." + req.Secret
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Secret), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	req.Credential.Hash = string(hash)
+	if err := repo.repo.UpdateCredential(req.Credential); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+func (repo *repoWrapper) delCredential(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/credentials/")
+
+	log.Printf("Trying to DELETE credential for %s", id)
+
+	if status, err := repo.repo.DeleteCredential(id); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeCache removes the cached responses matching the route, host and prefix query parameters, or all of them
+func (repo *repoWrapper) purgeCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	purged := repo.upstreams.Purge(query.Get("route"), query.Get("host"), query.Get("prefix"))
+
+	js, err := json.Marshal(map[string]int{"purged": purged})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
 // Mux returns the path multiplexer for the API
 func (repo *repoWrapper) Mux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/echo", echo)
 	mux.HandleFunc("/api/v1/routes/", repo.routes)
+	mux.HandleFunc("/api/v1/upstreams/", repo.getUpstreams)
+	mux.HandleFunc("/api/v1/breakers/", repo.getBreakers)
+	mux.HandleFunc("/api/v1/cache/", repo.purgeCache)
+	mux.HandleFunc("/api/v1/credentials/", repo.credentials)
 	return mux
 }
 
 // New returns a new instance of the proxy
-func New(repo routes.Repository) API {
+func New(repo routes.Repository, upstreams reverse.Proxy) API {
 	return API(&repoWrapper{
-		repo: repo,
+		repo:      repo,
+		upstreams: upstreams,
 	})
 }
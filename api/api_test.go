@@ -4,17 +4,20 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
 	"github.com/ocelotconsulting/go-ocelot/mocks"
 	"github.com/ocelotconsulting/go-ocelot/types"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var apiUnderTest *http.ServeMux
 var respRec *httptest.ResponseRecorder
 var req *http.Request
 var err error
+var stored types.Credential
 
 func setupRoutes() map[string]types.Route {
 	routes := make(map[string]types.Route)
@@ -26,14 +29,44 @@ func setupRoutes() map[string]types.Route {
 	return routes
 }
 
+func setupUpstreams() map[string][]types.TargetStatus {
+	upstreams := make(map[string][]types.TargetStatus)
+	upstreams["test"] = []types.TargetStatus{
+		{URL: "http://test:8080", Weight: 1, Healthy: true},
+		{URL: "http://test2:8080", Weight: 1, Healthy: false},
+	}
+	return upstreams
+}
+
+func setupBreakers() map[string]types.BreakerStatus {
+	breakers := make(map[string]types.BreakerStatus)
+	breakers["test"] = types.BreakerStatus{State: types.BreakerOpen, Transitions: 1}
+	return breakers
+}
+
+func setupCredentials() map[string]types.Credential {
+	credentials := make(map[string]types.Credential)
+	credentials["tools"] = types.Credential{ID: "tools", Type: types.AuthAPIKey, Hash: "$2a$10$hash", Routes: []string{"test"}}
+	return credentials
+}
+
 func setup(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	repoMock := mocks.NewMockRepository(ctrl)
 	routes := setupRoutes()
 	repoMock.EXPECT().Routes().Return(routes).AnyTimes()
+	repoMock.EXPECT().Credentials().Return(setupCredentials()).AnyTimes()
+	repoMock.EXPECT().UpdateCredential(gomock.Any()).DoAndReturn(func(credential types.Credential) error {
+		stored = credential
+		return nil
+	}).AnyTimes()
+	proxyMock := mocks.NewMockProxy(ctrl)
+	proxyMock.EXPECT().Upstreams().Return(setupUpstreams()).AnyTimes()
+	proxyMock.EXPECT().Breakers().Return(setupBreakers()).AnyTimes()
+	proxyMock.EXPECT().Purge("test", "", "/static/").Return(2).AnyTimes()
 	//mux router with added question routes
-	apiUnderTest = New(repoMock).Mux()
+	apiUnderTest = New(repoMock, proxyMock).Mux()
 
 	//The response recorder used to record HTTP responses
 	respRec = httptest.NewRecorder()
@@ -123,3 +156,155 @@ func TestMuxGetTestRoute(t *testing.T) {
 	}
 
 }
+
+func TestMuxPutInvalidRoute(t *testing.T) {
+	setup(t)
+	body := strings.NewReader(`{"id": "bad", "proxiedURL": "bad.ocelot.com/(unclosed", "patternType": "regex"}`)
+	req, err = http.NewRequest("PUT", "/api/v1/routes/", body)
+	if err != nil {
+		t.Fatal("Creating 'PUT /api/v1/routes/' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	if respRec.Code != http.StatusBadRequest {
+		t.Fatal("Server error: Returned ", respRec.Code, " instead of ", http.StatusBadRequest)
+	}
+}
+
+func TestMuxGetUpstreams(t *testing.T) {
+	setup(t)
+	req, err = http.NewRequest("GET", "/api/v1/upstreams/test", nil)
+	if err != nil {
+		t.Fatal("Creating 'GET /api/v1/upstreams/test' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	if respRec.Code != http.StatusOK {
+		t.Fatal("Server error: Returned ", respRec.Code, " instead of ", http.StatusOK)
+	}
+
+	var targets []types.TargetStatus
+	json.NewDecoder(respRec.Body).Decode(&targets)
+
+	if len(targets) != 2 || targets[1].Healthy {
+		t.Fatal("Server error: Returned invalid upstreams ", targets)
+	}
+}
+
+func TestMuxGetMissingUpstreams(t *testing.T) {
+	setup(t)
+	req, err = http.NewRequest("GET", "/api/v1/upstreams/missing", nil)
+	if err != nil {
+		t.Fatal("Creating 'GET /api/v1/upstreams/missing' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	if respRec.Code != http.StatusNotFound {
+		t.Fatal("Server error: Returned ", respRec.Code, " instead of ", http.StatusNotFound)
+	}
+}
+
+func TestMuxGetBreakers(t *testing.T) {
+	setup(t)
+	req, err = http.NewRequest("GET", "/api/v1/breakers/test", nil)
+	if err != nil {
+		t.Fatal("Creating 'GET /api/v1/breakers/test' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	if respRec.Code != http.StatusOK {
+		t.Fatal("Server error: Returned ", respRec.Code, " instead of ", http.StatusOK)
+	}
+
+	var breaker types.BreakerStatus
+	json.NewDecoder(respRec.Body).Decode(&breaker)
+
+	if breaker.State != types.BreakerOpen {
+		t.Fatal("Server error: Returned breaker state ", breaker.State, " instead of ", types.BreakerOpen)
+	}
+}
+
+func TestMuxPurgeCache(t *testing.T) {
+	setup(t)
+	req, err = http.NewRequest("DELETE", "/api/v1/cache/?route=test&prefix=/static/", nil)
+	if err != nil {
+		t.Fatal("Creating 'DELETE /api/v1/cache/' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	if respRec.Code != http.StatusOK {
+		t.Fatal("Server error: Returned ", respRec.Code, " instead of ", http.StatusOK)
+	}
+
+	var result map[string]int
+	json.NewDecoder(respRec.Body).Decode(&result)
+
+	if result["purged"] != 2 {
+		t.Fatal("Server error: Returned ", result["purged"], " purged instead of ", 2)
+	}
+}
+
+func TestMuxGetCredentialsHidesHashes(t *testing.T) {
+	setup(t)
+	req, err = http.NewRequest("GET", "/api/v1/credentials/tools", nil)
+	if err != nil {
+		t.Fatal("Creating 'GET /api/v1/credentials/tools' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	var credential types.Credential
+	json.NewDecoder(respRec.Body).Decode(&credential)
+
+	if respRec.Code != http.StatusOK || credential.ID != "tools" {
+		t.Fatal("Server error: Returned ", respRec.Code, " with credential ", credential.ID, " instead of tools")
+	}
+	if credential.Hash != "" {
+		t.Fatal("Server error: Returned the hash of a credential")
+	}
+}
+
+func TestMuxPutCredentialGeneratesAPIKey(t *testing.T) {
+	setup(t)
+	req, err = http.NewRequest("POST", "/api/v1/credentials/", strings.NewReader(`{"id": "tools", "type": "apiKey", "routes": ["test"]}`))
+	if err != nil {
+		t.Fatal("Creating 'POST /api/v1/credentials/' request failed!")
+	}
+
+	apiUnderTest.ServeHTTP(respRec, req)
+
+	if respRec.Code != http.StatusOK {
+		t.Fatal("Server error: Returned ", respRec.Code, " instead of ", http.StatusOK)
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	json.NewDecoder(respRec.Body).Decode(&created)
+	if !strings.HasPrefix(created.Key, "tools.") {
+		t.Fatal("Server error: Returned key ", created.Key, " instead of one starting with tools.")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Hash), []byte(strings.TrimPrefix(created.Key, "tools."))) != nil {
+		t.Fatal("Server error: Stored hash does not match the returned key")
+	}
+}
+
+func TestMuxPutCredentialValidates(t *testing.T) {
+	for _, body := range []string{`{"type": "basic", "secret": "s"}`, `{"id": "u", "type": "basic"}`, `{"id": "a.b", "type": "apiKey"}`, `{"id": "u", "type": "digest"}`} {
+		setup(t)
+		req, err = http.NewRequest("PUT", "/api/v1/credentials/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal("Creating 'PUT /api/v1/credentials/' request failed!")
+		}
+
+		apiUnderTest.ServeHTTP(respRec, req)
+
+		if respRec.Code != http.StatusBadRequest {
+			t.Fatal("Server error: Returned ", respRec.Code, " for ", body, " instead of ", http.StatusBadRequest)
+		}
+	}
+}
@@ -12,6 +12,11 @@ type Cache interface {
 	DeleteField(string, string) error
 	GetAll(key string) (map[string]string, error)
 	Subscribe(string, func()) error
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(keys ...string) error
+	Keys(pattern string) ([]string, error)
+	Increment(key string, ttl time.Duration) (int64, error)
 }
 
 type poolWrapper struct {
@@ -23,11 +28,12 @@ func (c *poolWrapper) SetField(key, field, value string) error {
 	conn := c.pool.Get()
 	defer conn.Close()
 
-	conn.Send("PUBLISH", "go-ocelot", "updated")
 	if _, err := conn.Do("HSET", key, field, value); err != nil {
 		return err
 	}
-	return nil
+	// published once the change is stored, so subscribers reloading see it
+	_, err := conn.Do("PUBLISH", "go-ocelot", "updated")
+	return err
 }
 
 // DeleteField removes a hash from a key
@@ -35,11 +41,12 @@ func (c *poolWrapper) DeleteField(key, field string) error {
 	conn := c.pool.Get()
 	defer conn.Close()
 
-	conn.Send("PUBLISH", "go-ocelot", "updated")
 	if _, err := conn.Do("HDEL", key, field); err != nil {
 		return err
 	}
-	return nil
+	// published once the change is stored, so subscribers reloading see it
+	_, err := conn.Do("PUBLISH", "go-ocelot", "updated")
+	return err
 }
 
 // Subscribe subscribes to a key and calls a function when messages are received
@@ -73,6 +80,82 @@ func (c *poolWrapper) GetAll(key string) (map[string]string, error) {
 	return result, nil
 }
 
+// Get returns the value of a key, or nil when it does not exist
+func (c *poolWrapper) Get(key string) ([]byte, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return value, err
+}
+
+// Set stores a value under a key that expires after ttl
+func (c *poolWrapper) Set(key string, value []byte, ttl time.Duration) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("SET", key, value, "PX", int64(ttl/time.Millisecond)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete removes keys
+func (c *poolWrapper) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", redis.Args{}.AddFlat(keys)...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Keys returns the keys matching a glob pattern, scanning instead of blocking redis with KEYS
+func (c *poolWrapper) Keys(pattern string) ([]string, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	var keys []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return keys, err
+		}
+		var batch []string
+		if _, err := redis.Scan(values, &cursor, &batch); err != nil {
+			return keys, err
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// Increment atomically adds one to a counter, which expires ttl after it is created
+func (c *poolWrapper) Increment(key string, ttl time.Duration) (int64, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	// creating the counter with its expiry first keeps INCR from resetting it
+	conn.Send("MULTI")
+	conn.Send("SET", key, 0, "PX", int64(ttl/time.Millisecond), "NX")
+	conn.Send("INCR", key)
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int64(values[1], nil)
+}
+
 // New returns an initialized instance of a cache.
 func New(address string) Cache {
 	return Cache(&poolWrapper{pool: &redis.Pool{
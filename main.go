@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
@@ -8,9 +9,13 @@ import (
 	"os"
 
 	service "github.com/ocelotconsulting/go-ocelot/api"
+	"github.com/ocelotconsulting/go-ocelot/cache"
 	"github.com/ocelotconsulting/go-ocelot/middleware"
 	"github.com/ocelotconsulting/go-ocelot/proxy"
+	"github.com/ocelotconsulting/go-ocelot/proxy/reverse"
 	"github.com/ocelotconsulting/go-ocelot/routes"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type ports struct {
@@ -18,6 +23,29 @@ type ports struct {
 	serverTLSPort string
 }
 
+// newHandler assembles the middleware chain in front of the admin API and the proxy
+func newHandler(repo routes.Repository, upstreams reverse.Proxy) http.Handler {
+	proxy := proxy.New(repo, upstreams)
+
+	api := service.New(repo, upstreams)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api.Mux())
+	mux.HandleFunc("/", proxy.ServeHTTP)
+
+	loggedHandler := middleware.LoggedHandler(mux)
+	headeredHandler := middleware.HeaderedHandler(loggedHandler)
+	return middleware.CORSHandler(headeredHandler)
+}
+
+// newMetricsHandler publishes the metrics, which include the command line, so
+// it is served on its own listener rather than to proxy clients
+func newMetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
 func main() {
 	start(os.Args)
 }
@@ -29,37 +57,45 @@ func start(args []string) {
 	}
 
 	redisURL := flag.String("redisURL", "redis:6379", "redis url, 'redis:6379'")
+	maxPathDepth := flag.Int("maxPathDepth", 0, "maximum path segments matched when resolving routes, 0 for no limit")
+	stickySecret := flag.String("stickySecret", os.Getenv("STICKY_SECRET"), "key signing sticky session cookies, random if empty")
+	metricsAddr := flag.String("metricsAddr", "127.0.0.1:8090", "address serving metrics on /debug/vars, empty to disable")
 
 	flag.Parse()
 
 	fmt.Println(fmt.Sprintf("running on HTTP: %s, TLS: %s", config.serverPort, config.serverTLSPort))
 
 	//  Start Route Synchronizer
-	repo := routes.New(10, *redisURL)
+	repo := routes.New(10, *redisURL, *maxPathDepth)
 	repo.Start()
 
-	proxy := proxy.New(repo)
+	//  Start Upstream Health Checks
+	upstreams := reverse.New(repo, cache.New(*redisURL), *stickySecret)
+	upstreams.Start()
 
-	api := service.New(repo)
-
-	mux := http.NewServeMux()
-	mux.Handle("/api/", api.Mux())
-	mux.HandleFunc("/", proxy.ServeHTTP)
+	handler := newHandler(repo, upstreams)
 
-	loggedHandler := middleware.LoggedHandler(mux)
-	headeredHandler := middleware.HeaderedHandler(loggedHandler)
-	corsHandler := middleware.CORSHandler(headeredHandler)
+	//  Start Metrics
+	if *metricsAddr != "" {
+		go func() {
+			errMetrics := http.ListenAndServe(*metricsAddr, newMetricsHandler())
+			if errMetrics != nil {
+				log.Printf("Metrics Serving Error: %v", errMetrics)
+			}
+		}()
+	}
 
 	//  Start HTTP
 	go func() {
-		errHTTP := http.ListenAndServe(config.serverPort, corsHandler)
+		// accept HTTP/2 without TLS too, for gRPC clients
+		errHTTP := http.ListenAndServe(config.serverPort, h2c.NewHandler(handler, &http2.Server{}))
 		if errHTTP != nil {
 			log.Fatal("HTTP Serving Error: ", errHTTP)
 		}
 	}()
 
 	// Start TLS
-	errTLS := http.ListenAndServeTLS(config.serverTLSPort, "cert.pem", "key.pem", corsHandler)
+	errTLS := http.ListenAndServeTLS(config.serverTLSPort, "cert.pem", "key.pem", handler)
 	if errTLS != nil {
 		log.Fatal("TLS Serving Error: ", errTLS)
 	}
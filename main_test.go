@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/ocelotconsulting/go-ocelot/mocks"
+	"github.com/ocelotconsulting/go-ocelot/proxy/reverse"
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// stack serves route through the same handler chain as the proxy
+func stack(t *testing.T, route types.Route) *httptest.Server {
+	ctrl := gomock.NewController(t)
+	routeMap := map[string]types.Route{route.ID: route}
+	repo := mocks.NewMockRepository(ctrl)
+	repo.EXPECT().Routes().Return(routeMap).AnyTimes()
+	repo.EXPECT().Index().Return(routes.NewIndex(routeMap, 0)).AnyTimes()
+	return httptest.NewServer(newHandler(repo, reverse.New(repo, nil, "secret")))
+}
+
+// echoSocket accepts WebSocket upgrades and echoes back whatever it receives
+func echoSocket(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("x-forwarded-proto") != "http" {
+			t.Error("Upstream received headers ", r.Header, " instead of a proxied upgrade")
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error("Hijacking failed: ", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+}
+
+// dialSocket upgrades a connection through the proxy, returning it once the upstream has switched protocols
+func dialSocket(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader, int) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal("Dialing proxy failed: ", err)
+	}
+	fmt.Fprint(conn, "GET /socket HTTP/1.1\r\nHost: ocelot.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nOrigin: http://ocelot.com\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal("Reading upgrade response failed: ", err)
+	}
+	return conn, reader, resp.StatusCode
+}
+
+func TestWebSocketThroughStack(t *testing.T) {
+	upstream := echoSocket(t)
+	defer upstream.Close()
+	server := stack(t, types.Route{
+		ID:         "socket",
+		ProxiedURL: "ocelot.com/socket",
+		Upstream:   upstream.URL,
+		WebSocket:  &types.WebSocket{IdleTimeout: types.Duration(100 * time.Millisecond), MaxConnections: 1},
+	})
+	defer server.Close()
+
+	conn, reader, code := dialSocket(t, server)
+	defer conn.Close()
+	if code != http.StatusSwitchingProtocols {
+		t.Fatal("Proxy answered upgrade with ", code, " instead of ", http.StatusSwitchingProtocols)
+	}
+	for _, message := range []string{"hello\n", "again\n"} {
+		fmt.Fprint(conn, message)
+		if echoed, err := reader.ReadString('\n'); err != nil || echoed != message {
+			t.Fatal("Upstream echoed ", echoed, " instead of ", message, ": ", err)
+		}
+	}
+
+	refused, _, code := dialSocket(t, server)
+	refused.Close()
+	if code != http.StatusServiceUnavailable {
+		t.Fatal("Proxy answered upgrade over the limit with ", code, " instead of ", http.StatusServiceUnavailable)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadString('\n'); err != io.EOF {
+		t.Fatal("Idle connection was not closed: ", err)
+	}
+	// the closed connection no longer counts towards the limit
+	var again net.Conn
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		conn, _, code := dialSocket(t, server)
+		if code == http.StatusSwitchingProtocols {
+			again = conn
+			break
+		}
+		conn.Close()
+	}
+	if again == nil {
+		t.Fatal("Proxy kept refusing upgrades after the idle connection closed")
+	}
+	again.Close()
+}
+
+func TestMetricsNotServedToProxyClients(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	defer upstream.Close()
+	server := stack(t, types.Route{ID: "debug", ProxiedURL: "ocelot.com/debug", Upstream: upstream.URL})
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/debug/vars", nil)
+	req.Host = "ocelot.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Requesting /debug/vars failed: ", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "proxied" {
+		t.Fatal("Proxy answered /debug/vars with ", string(body), " instead of the route's upstream")
+	}
+
+	respRec := httptest.NewRecorder()
+	newMetricsHandler().ServeHTTP(respRec, httptest.NewRequest("GET", "/debug/vars", nil))
+	if !strings.Contains(respRec.Body.String(), "cmdline") {
+		t.Fatal("Metrics handler answered ", respRec.Body.String(), " instead of the metrics")
+	}
+}
+
+func TestServerSentEventsThroughStack(t *testing.T) {
+	next := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	}))
+	defer upstream.Close()
+	server := stack(t, types.Route{
+		ID:            "events",
+		ProxiedURL:    "ocelot.com/events",
+		Upstream:      upstream.URL,
+		FlushInterval: types.Duration(-1),
+	})
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	req.Host = "ocelot.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Requesting events failed: ", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("powered-by") != "go-ocelot" {
+		t.Fatal("Response did not pass through the middleware chain")
+	}
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 2; i++ {
+		event, err := reader.ReadString('\n')
+		if err != nil || event != fmt.Sprintf("data: %d\n", i) {
+			t.Fatal("Received ", event, " instead of event ", i, ": ", err)
+		}
+		reader.ReadString('\n')
+		// the upstream only sends the next event once this one arrived
+		next <- struct{}{}
+	}
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const defaultCompressionMinSize = 1024
+
+var (
+	defaultEncodings    = []string{types.EncodingBrotli, types.EncodingGzip}
+	defaultContentTypes = []string{
+		"text/*",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"image/svg+xml",
+	}
+	// encoders are reused, indexed by compression level
+	gzipPools, brotliPools [10]sync.Pool
+)
+
+// encoder is implemented by both gzip and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+func newEncoder(encoding string, level int, w io.Writer) encoder {
+	pools := &gzipPools
+	if encoding == types.EncodingBrotli {
+		pools = &brotliPools
+	}
+	if e, ok := pools[level].Get().(encoder); ok {
+		e.Reset(w)
+		return e
+	}
+	if encoding == types.EncodingBrotli {
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level)
+	}
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	e, _ := gzip.NewWriterLevel(w, level)
+	return e
+}
+
+func releaseEncoder(encoding string, level int, e encoder) {
+	if encoding == types.EncodingBrotli {
+		brotliPools[level].Put(e)
+	} else {
+		gzipPools[level].Put(e)
+	}
+}
+
+// acceptQuality returns the quality an Accept-Encoding header gives an encoding, 0 when it is not acceptable
+func acceptQuality(acceptEncoding, encoding string) float64 {
+	quality, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		value := 1.0
+		for _, param := range params[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					value = q
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case encoding:
+			quality = value
+		case "*":
+			wildcard = value
+		}
+	}
+	if quality >= 0 {
+		return quality
+	}
+	if wildcard >= 0 {
+		return wildcard
+	}
+	return 0
+}
+
+// negotiate picks the offered encoding the client prefers, ties going to the first offered
+func negotiate(acceptEncoding string, offered []string) string {
+	best, bestQuality := "", 0.0
+	for _, encoding := range offered {
+		if quality := acceptQuality(acceptEncoding, encoding); quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compressibleType reports whether a Content-Type matches one of the types, which may end with a /* wildcard
+func compressibleType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range patterns {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter compresses the response written through it when it is eligible
+type compressWriter struct {
+	http.ResponseWriter
+	config      *types.Compression
+	encoding    string
+	encoder     encoder
+	wroteHeader bool
+}
+
+// compress decides whether to compress a response with its status and headers
+func (w *compressWriter) compress(code int) bool {
+	header := w.Header()
+	contentTypes := w.config.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultContentTypes
+	}
+	if !compressibleType(header.Get("Content-Type"), contentTypes) {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(strings.Join(header["Vary"], ",")), "accept-encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if w.encoding == "" || code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent ||
+		header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	minSize := w.config.MinSize
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < minSize {
+		return false
+	}
+	return true
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code < http.StatusOK || w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	if w.compress(code) {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		// the compressed representation is no longer byte for byte the one tagged
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.encoder = newEncoder(w.encoding, w.config.Level, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what was compressed so far, so streamed responses keep flowing
+func (w *compressWriter) Flush() {
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.encoder != nil {
+		w.encoder.Close()
+		releaseEncoder(w.encoding, w.config.Level, w.encoder)
+	}
+}
+
+// CompressedHandler compresses the responses of routes configuring compression with the encoding the client prefers
+func CompressedHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := routes.FromContext(r.Context())
+		if match == nil || match.Route.Compression == nil || r.Method == "HEAD" || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		config := match.Route.Compression
+		offered := config.Encodings
+		if len(offered) == 0 {
+			offered = defaultEncodings
+		}
+		cw := &compressWriter{ResponseWriter: w, config: config, encoding: negotiate(r.Header.Get("Accept-Encoding"), offered)}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
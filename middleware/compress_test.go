@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+var document = strings.Repeat("go-ocelot compresses responses. ", 100)
+
+// compressedRequest serves a request for a route through the compression middleware
+func compressedRequest(t *testing.T, route types.Route, acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://ocelot.com/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	match := routes.ResolveRoute(req, routes.NewIndex(map[string]types.Route{route.ID: route}, 0))
+	if match == nil {
+		t.Fatal("No route resolved for ", route.ProxiedURL)
+	}
+	respRec := httptest.NewRecorder()
+	CompressedHandler(h).ServeHTTP(respRec, req.WithContext(routes.NewContext(req.Context(), match)))
+	return respRec
+}
+
+func serveDocument(contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(document))
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip, deflate, br", types.EncodingBrotli},
+		{"gzip, br;q=0.5", types.EncodingGzip},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", types.EncodingBrotli},
+		{"identity", ""},
+	}
+	for _, test := range tests {
+		if encoding := negotiate(test.acceptEncoding, defaultEncodings); encoding != test.expected {
+			t.Fatal("Negotiated ", encoding, " for ", test.acceptEncoding, " instead of ", test.expected)
+		}
+	}
+}
+
+func TestCompressesEligibleResponses(t *testing.T) {
+	route := types.Route{ID: "compressed", ProxiedURL: "ocelot.com", Compression: &types.Compression{}}
+
+	respRec := compressedRequest(t, route, "gzip", serveDocument("text/html; charset=utf-8"))
+	if encoding := respRec.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatal("Response was encoded with ", encoding, " instead of gzip")
+	}
+	reader, err := gzip.NewReader(respRec.Body)
+	if err != nil {
+		t.Fatal("Reading gzip response failed: ", err)
+	}
+	if body, _ := ioutil.ReadAll(reader); string(body) != document {
+		t.Fatal("Decompressed response did not match the document")
+	}
+
+	respRec = compressedRequest(t, route, "br", serveDocument("application/json"))
+	if body, _ := ioutil.ReadAll(brotli.NewReader(respRec.Body)); string(body) != document {
+		t.Fatal("Decompressed brotli response did not match the document")
+	}
+	if vary := respRec.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Fatal("Response varied on ", vary, " instead of Accept-Encoding")
+	}
+}
+
+func TestSkipsIneligibleResponses(t *testing.T) {
+	route := types.Route{ID: "compressed", ProxiedURL: "ocelot.com", Compression: &types.Compression{MinSize: 4096}}
+	small := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte("small"))
+	}
+
+	for _, h := range []http.HandlerFunc{small, serveDocument("image/png")} {
+		if encoding := compressedRequest(t, route, "gzip", h).Header().Get("Content-Encoding"); encoding != "" {
+			t.Fatal("Ineligible response was encoded with ", encoding)
+		}
+	}
+	if encoding := compressedRequest(t, types.Route{ID: "plain", ProxiedURL: "ocelot.com"}, "gzip", serveDocument("text/plain")).Header().Get("Content-Encoding"); encoding != "" {
+		t.Fatal("Response of a route without compression was encoded with ", encoding)
+	}
+}
+
+func TestCompressesStreams(t *testing.T) {
+	route := types.Route{ID: "stream", ProxiedURL: "ocelot.com", Compression: &types.Compression{Encodings: []string{types.EncodingGzip}}}
+	flushed := 0
+	respRec := compressedRequest(t, route, "gzip, br", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		flushed = len(w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.Bytes())
+	})
+
+	if flushed == 0 {
+		t.Fatal("Flushing the stream did not send the compressed event")
+	}
+	reader, err := gzip.NewReader(respRec.Body)
+	if err != nil {
+		t.Fatal("Reading gzip stream failed: ", err)
+	}
+	if body, _ := ioutil.ReadAll(reader); string(body) != "data: 1\n\n" {
+		t.Fatal("Decompressed stream was ", string(body), " instead of the event")
+	}
+}
@@ -4,21 +4,19 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/ocelotconsulting/go-ocelot/middleware"
 	"github.com/ocelotconsulting/go-ocelot/proxy/reverse"
 	"github.com/ocelotconsulting/go-ocelot/routes"
 )
 
-//New returns a handler that will proxy incoming requests
-func New(repo routes.Repository) http.Handler {
-	proxy := reverse.New(repo)
+//New returns a handler that will proxy incoming requests to their upstreams
+func New(repo routes.Repository, proxy reverse.Proxy) http.Handler {
+	// compression is configured per route, so it applies once the route is resolved
+	compressed := middleware.CompressedHandler(proxy)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Proxy handler trying to route %s with path %s", r.Host, r.URL.Path)
-		pathToMatch := ""
-		if r.URL.Path != "/" {
-			pathToMatch = r.URL.Path
-		}
-		if route := routes.ResolveRoute(pathToMatch, r.Host, repo.Routes()); route != nil {
-			proxy.ServeHTTP(w, r)
+		if match := routes.ResolveRoute(r, repo.Index()); match != nil {
+			compressed.ServeHTTP(w, r.WithContext(routes.NewContext(r.Context(), match)))
 			return
 		}
 		// no pattern matched; send 404 response
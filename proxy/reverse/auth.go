@@ -0,0 +1,109 @@
+package reverse
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultAPIKeyHeader = "X-API-Key"
+	// bcrypt is deliberately slow, so verified secrets are remembered for a while
+	verifiedTTL     = 5 * time.Minute
+	maxVerifiedKeys = 10000
+)
+
+// verifiedSecrets remembers secrets that matched a hash, changing the hash forgets them
+type verifiedSecrets struct {
+	mux     sync.Mutex
+	entries map[[sha256.Size]byte]time.Time
+}
+
+func newVerifiedSecrets() *verifiedSecrets {
+	return &verifiedSecrets{entries: make(map[[sha256.Size]byte]time.Time)}
+}
+
+func (v *verifiedSecrets) verify(hash, secret string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + secret))
+	v.mux.Lock()
+	expires, ok := v.entries[key]
+	v.mux.Unlock()
+	if ok && time.Now().Before(expires) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return false
+	}
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if len(v.entries) >= maxVerifiedKeys {
+		v.entries = make(map[[sha256.Size]byte]time.Time)
+	}
+	v.entries[key] = time.Now().Add(verifiedTTL)
+	return true
+}
+
+// presentedCredential returns the credential ID and secret a request carries
+// for the route's kind of authentication, removing them from the request so
+// they are not passed upstream
+func presentedCredential(req *http.Request, config *types.Auth) (string, string, bool) {
+	if config.Type == types.AuthBasic {
+		username, password, ok := req.BasicAuth()
+		req.Header.Del("Authorization")
+		return username, password, ok
+	}
+	var key string
+	if config.Query != "" {
+		query := req.URL.Query()
+		if key = query.Get(config.Query); key != "" {
+			query.Del(config.Query)
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+	header := config.Header
+	if header == "" {
+		header = defaultAPIKeyHeader
+	}
+	if key == "" {
+		key = req.Header.Get(header)
+	}
+	req.Header.Del(header)
+	i := strings.Index(key, ".")
+	if i <= 0 {
+		return "", "", false
+	}
+	return key[:i], key[i+1:], true
+}
+
+// authorize checks the API key or basic auth credential of the request
+// against those stored for the route, and answers 401 when it is not valid
+func (p *proxyWrapper) authorize(w http.ResponseWriter, req *http.Request, route *types.Route) bool {
+	config := route.Auth
+	if config == nil {
+		return true
+	}
+	if id, secret, ok := presentedCredential(req, config); ok && p.repo != nil {
+		credential, found := p.repo.Credentials()[id]
+		if found && credential.Type == config.Type &&
+			(len(credential.Routes) == 0 || containsString(credential.Routes, route.ID)) &&
+			p.verified.verify(credential.Hash, secret) {
+			return true
+		}
+	}
+	authRejections.Add(route.ID, 1)
+	if config.Type == types.AuthBasic {
+		realm := config.Realm
+		if realm == "" {
+			realm = route.ID
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+	}
+	writeError(w, req, http.StatusUnauthorized, "Unauthorized")
+	return false
+}
@@ -0,0 +1,84 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/ocelotconsulting/go-ocelot/mocks"
+	"github.com/ocelotconsulting/go-ocelot/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credentialsProxy returns a proxy whose repository holds a credential for the secret
+func credentialsProxy(t *testing.T, credential types.Credential, secret string) Proxy {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal("Hashing secret failed: ", err)
+	}
+	credential.Hash = string(hash)
+	repo := mocks.NewMockRepository(gomock.NewController(t))
+	repo.EXPECT().Credentials().Return(map[string]types.Credential{credential.ID: credential}).AnyTimes()
+	return New(repo, nil, "secret")
+}
+
+// keyUpstream answers with the API key headers and query it received
+func keyUpstream() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-API-Key") + r.Header.Get("Authorization") + r.URL.RawQuery))
+	}))
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	server := keyUpstream()
+	defer server.Close()
+	route := types.Route{ID: "tools", ProxiedURL: "ocelot.com", Upstream: server.URL, Auth: &types.Auth{Type: types.AuthAPIKey, Query: "apikey"}}
+	proxy := credentialsProxy(t, types.Credential{ID: "ci", Type: types.AuthAPIKey, Routes: []string{"tools"}}, "s3cret")
+
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.Header.Set("X-API-Key", "ci.s3cret")
+	if code, body := serve(proxy, req); code != http.StatusOK || body != "" {
+		t.Fatal("Valid API key header was answered ", code, " with ", body, " instead of 200 without the key")
+	}
+	if code, body := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/?apikey=ci.s3cret&page=2", route)); code != http.StatusOK || body != "page=2" {
+		t.Fatal("Valid API key parameter was answered ", code, " with ", body, " instead of 200 without the key")
+	}
+	for _, key := range []string{"", "ci.wrong", "other.s3cret", "s3cret"} {
+		req := routedRequest(t, "GET", "http://ocelot.com/", route)
+		req.Header.Set("X-API-Key", key)
+		if code, _ := serve(proxy, req); code != http.StatusUnauthorized {
+			t.Fatal("API key ", key, " was answered ", code, " instead of ", http.StatusUnauthorized)
+		}
+	}
+
+	// credentials only grant access to their routes
+	other := route
+	other.ID = "other"
+	req = routedRequest(t, "GET", "http://ocelot.com/", other)
+	req.Header.Set("X-API-Key", "ci.s3cret")
+	if code, _ := serve(proxy, req); code != http.StatusUnauthorized {
+		t.Fatal("API key for another route was answered ", code, " instead of ", http.StatusUnauthorized)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	server := keyUpstream()
+	defer server.Close()
+	route := types.Route{ID: "admin", ProxiedURL: "ocelot.com", Upstream: server.URL, Auth: &types.Auth{Type: types.AuthBasic, Realm: "Admin"}}
+	proxy := credentialsProxy(t, types.Credential{ID: "larry", Type: types.AuthBasic}, "hunter2")
+
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.SetBasicAuth("larry", "hunter2")
+	if code, body := serve(proxy, req); code != http.StatusOK || body != "" {
+		t.Fatal("Valid basic auth was answered ", code, " with ", body, " instead of 200 without the credentials")
+	}
+
+	req = routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.SetBasicAuth("larry", "wrong")
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, req)
+	if respRec.Code != http.StatusUnauthorized || respRec.Header().Get("WWW-Authenticate") != `Basic realm="Admin"` {
+		t.Fatal("Wrong password was answered ", respRec.Code, " with challenge ", respRec.Header().Get("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,245 @@
+package reverse
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// Balancer picks which target of a route receives a request, it returns nil
+// when none of the targets are available. Balancers are shared by every
+// request to a route and must be safe for concurrent use.
+type Balancer interface {
+	Next(req *http.Request) *Target
+}
+
+// BalancerFunc adapts a function to the Balancer interface
+type BalancerFunc func(req *http.Request) *Target
+
+// Next calls f(req)
+func (f BalancerFunc) Next(req *http.Request) *Target {
+	return f(req)
+}
+
+// BalancerFactory creates a balancer over the targets of a route
+type BalancerFactory func(config *types.LoadBalancing, targets []*Target) Balancer
+
+var balancers = map[string]BalancerFactory{
+	types.BalanceRoundRobin:       newRoundRobin,
+	types.BalanceWeighted:         newWeighted,
+	types.BalanceLeastConnections: newLeastConnections,
+	types.BalanceRandomTwo:        newRandomTwo,
+	types.BalanceConsistentHash:   newConsistentHash,
+}
+
+// RegisterBalancer makes a balancing strategy available to routes by name,
+// it is meant to be called during initialization before requests are served
+func RegisterBalancer(name string, factory BalancerFactory) {
+	balancers[name] = factory
+}
+
+func newBalancer(config *types.LoadBalancing, targets []*Target) Balancer {
+	if config == nil || config.Strategy == "" {
+		return newRoundRobin(config, targets)
+	}
+	factory, ok := balancers[config.Strategy]
+	if !ok {
+		log.Printf("Unknown load balancing strategy %s, using %s", config.Strategy, types.BalanceRoundRobin)
+		return newRoundRobin(config, targets)
+	}
+	return factory(config, targets)
+}
+
+// roundRobin cycles through the available targets in order, ignoring weights
+type roundRobin struct {
+	targets []*Target
+	next    uint64
+}
+
+func newRoundRobin(config *types.LoadBalancing, targets []*Target) Balancer {
+	return &roundRobin{targets: targets}
+}
+
+func (b *roundRobin) Next(req *http.Request) *Target {
+	for range b.targets {
+		target := b.targets[(atomic.AddUint64(&b.next, 1)-1)%uint64(len(b.targets))]
+		if target.Available() {
+			return target
+		}
+	}
+	return nil
+}
+
+// weighted is a smooth weighted round robin, spreading the picks of heavier
+// targets evenly instead of sending them in bursts
+type weighted struct {
+	targets []*Target
+	current []int
+	mux     sync.Mutex
+}
+
+func newWeighted(config *types.LoadBalancing, targets []*Target) Balancer {
+	return &weighted{targets: targets, current: make([]int, len(targets))}
+}
+
+func (b *weighted) Next(req *http.Request) *Target {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	total, best := 0, -1
+	for i, target := range b.targets {
+		if !target.Available() {
+			continue
+		}
+		b.current[i] += target.Weight
+		total += target.Weight
+		if best < 0 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	b.current[best] -= total
+	return b.targets[best]
+}
+
+// lighter reports whether a has fewer requests in flight than b relative to their weights
+func lighter(a, b *Target) bool {
+	return a.Active()*int64(b.Weight) < b.Active()*int64(a.Weight)
+}
+
+// leastConnections picks the target with the fewest requests in flight
+// relative to its weight, starting from a rotating offset to spread ties
+type leastConnections struct {
+	targets []*Target
+	next    uint64
+}
+
+func newLeastConnections(config *types.LoadBalancing, targets []*Target) Balancer {
+	return &leastConnections{targets: targets}
+}
+
+func (b *leastConnections) Next(req *http.Request) *Target {
+	var best *Target
+	offset := atomic.AddUint64(&b.next, 1)
+	for i := range b.targets {
+		target := b.targets[(offset+uint64(i))%uint64(len(b.targets))]
+		if target.Available() && (best == nil || lighter(target, best)) {
+			best = target
+		}
+	}
+	return best
+}
+
+// randomTwo picks two available targets at random and uses the less loaded one
+type randomTwo struct {
+	targets []*Target
+}
+
+func newRandomTwo(config *types.LoadBalancing, targets []*Target) Balancer {
+	return &randomTwo{targets: targets}
+}
+
+func (b *randomTwo) Next(req *http.Request) *Target {
+	var available []*Target
+	for _, target := range b.targets {
+		if target.Available() {
+			available = append(available, target)
+		}
+	}
+	switch len(available) {
+	case 0:
+		return nil
+	case 1:
+		return available[0]
+	}
+	first := rand.Intn(len(available))
+	second := rand.Intn(len(available) - 1)
+	if second >= first {
+		second++
+	}
+	if lighter(available[second], available[first]) {
+		return available[second]
+	}
+	return available[first]
+}
+
+// replicas is how many points each unit of weight puts on the hash ring
+const replicas = 64
+
+type ringEntry struct {
+	hash   uint32
+	target *Target
+}
+
+// consistentHash maps a key from the request onto a ring of targets, so the
+// same key keeps reaching the same target while it is available. Requests
+// without a key are balanced round robin.
+type consistentHash struct {
+	config   types.LoadBalancing
+	ring     []ringEntry
+	fallback Balancer
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func newConsistentHash(config *types.LoadBalancing, targets []*Target) Balancer {
+	b := &consistentHash{config: *config, fallback: newRoundRobin(config, targets)}
+	for _, target := range targets {
+		for i := 0; i < replicas*target.Weight; i++ {
+			b.ring = append(b.ring, ringEntry{hash: hashKey(fmt.Sprintf("%s#%d", target.URL, i)), target: target})
+		}
+	}
+	sort.Sort(byHash(b.ring))
+	return b
+}
+
+type byHash []ringEntry
+
+func (r byHash) Len() int           { return len(r) }
+func (r byHash) Less(i, j int) bool { return r[i].hash < r[j].hash }
+func (r byHash) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (b *consistentHash) key(req *http.Request) string {
+	switch b.config.HashOn {
+	case types.HashOnHeader:
+		return req.Header.Get(b.config.HashKey)
+	case types.HashOnCookie:
+		if cookie, err := req.Cookie(b.config.HashKey); err == nil {
+			return cookie.Value
+		}
+	case types.HashOnIP:
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			return host
+		}
+		return req.RemoteAddr
+	}
+	return ""
+}
+
+func (b *consistentHash) Next(req *http.Request) *Target {
+	key := b.key(req)
+	if key == "" || len(b.ring) == 0 {
+		return b.fallback.Next(req)
+	}
+	hash := hashKey(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= hash })
+	for i := range b.ring {
+		if entry := b.ring[(start+i)%len(b.ring)]; entry.target.Available() {
+			return entry.target
+		}
+	}
+	return nil
+}
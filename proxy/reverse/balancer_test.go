@@ -0,0 +1,115 @@
+package reverse
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func targets(weights ...int) []*Target {
+	var list []*Target
+	for i, weight := range weights {
+		upstream, _ := routes.ParseUpstream(fmt.Sprintf("http://target%d:8080", i))
+		list = append(list, &Target{URL: upstream, Weight: weight})
+	}
+	return list
+}
+
+func pick(balancer Balancer, req *http.Request, times int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < times; i++ {
+		if target := balancer.Next(req); target != nil {
+			counts[target.URL.Host]++
+		}
+	}
+	return counts
+}
+
+func TestRoundRobin(t *testing.T) {
+	counts := pick(newBalancer(nil, targets(1, 5, 1)), httptest.NewRequest("GET", "/", nil), 9)
+	for host, count := range counts {
+		if count != 3 {
+			t.Fatal("Round robin picked ", host, " ", count, " times instead of 3")
+		}
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	balancer := newBalancer(&types.LoadBalancing{Strategy: types.BalanceWeighted}, targets(1, 3))
+	counts := pick(balancer, httptest.NewRequest("GET", "/", nil), 8)
+	if counts["target0:8080"] != 2 || counts["target1:8080"] != 6 {
+		t.Fatal("Weighted balancer picked ", counts, " instead of 2 and 6")
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	list := targets(1, 1, 1)
+	list[0].acquire()
+	list[2].acquire()
+	balancer := newBalancer(&types.LoadBalancing{Strategy: types.BalanceLeastConnections}, list)
+	for i := 0; i < 3; i++ {
+		if target := balancer.Next(httptest.NewRequest("GET", "/", nil)); target != list[1] {
+			t.Fatal("Least connections picked ", target.URL, " instead of ", list[1].URL)
+		}
+	}
+}
+
+func TestRandomTwoChoices(t *testing.T) {
+	list := targets(1, 1)
+	list[0].acquire()
+	balancer := newBalancer(&types.LoadBalancing{Strategy: types.BalanceRandomTwo}, list)
+	for i := 0; i < 10; i++ {
+		if target := balancer.Next(httptest.NewRequest("GET", "/", nil)); target != list[1] {
+			t.Fatal("Random two choices picked ", target.URL, " instead of ", list[1].URL)
+		}
+	}
+}
+
+func TestDrainedTargets(t *testing.T) {
+	drained := 0
+	b := newBackend(types.Route{ID: "draining", ProxiedURL: "ocelot.com", Targets: []types.Target{
+		{URL: "http://target0:8080"},
+		{URL: "http://target1:8080", Weight: &drained},
+	}})
+	counts := pick(b.balancer, httptest.NewRequest("GET", "/", nil), 4)
+	if counts["target0:8080"] != 4 {
+		t.Fatal("Balancer picked ", counts, " instead of only the target without a weight")
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	balancer := newBalancer(&types.LoadBalancing{
+		Strategy: types.BalanceConsistentHash, HashOn: types.HashOnHeader, HashKey: "X-User",
+	}, targets(1, 1, 1, 1))
+	seen := make(map[string]bool)
+	for user := 0; user < 20; user++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", fmt.Sprintf("user%d", user))
+		counts := pick(balancer, req, 5)
+		if len(counts) != 1 {
+			t.Fatal("Consistent hash spread user", user, " over ", counts)
+		}
+		for host := range counts {
+			seen[host] = true
+		}
+	}
+	if len(seen) < 2 {
+		t.Fatal("Consistent hash sent every user to ", seen)
+	}
+}
+
+func TestRegisterBalancer(t *testing.T) {
+	RegisterBalancer("first", func(config *types.LoadBalancing, targets []*Target) Balancer {
+		return BalancerFunc(func(req *http.Request) *Target { return targets[0] })
+	})
+	defer delete(balancers, "first")
+	list := targets(1, 1)
+	counts := pick(newBalancer(&types.LoadBalancing{Strategy: "first"}, list), httptest.NewRequest("GET", "/", nil), 3)
+	if counts["target0:8080"] != 3 {
+		t.Fatal("Registered balancer picked ", counts, " instead of always the first target")
+	}
+}
@@ -0,0 +1,162 @@
+package reverse
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultOpenTimeout         = 30 * time.Second
+	defaultHalfOpenRequests    = 1
+)
+
+// breaker is a route's circuit breaker, it is shared by every target of the route
+type breaker struct {
+	config  *types.CircuitBreaker
+	routeID string
+
+	mux         sync.Mutex
+	state       string
+	since       time.Time
+	failures    int
+	transitions int
+	// trial requests let through and succeeded while half-open
+	trials, successes int
+}
+
+// newBreaker returns nil when the route has no circuit breaker, which lets every request through
+func newBreaker(routeID string, config *types.CircuitBreaker) *breaker {
+	if config == nil {
+		return nil
+	}
+	setGauge(breakerState, routeID, types.BreakerClosed)
+	return &breaker{config: config, routeID: routeID, state: types.BreakerClosed, since: time.Now()}
+}
+
+// inherit takes over the state of the breaker of a previous backend of the
+// route, unless either has none or its configuration changed. Half-open trials
+// still in flight report to the previous breaker, so they are not carried over.
+func (b *breaker) inherit(old *breaker) {
+	if b == nil || old == nil || !reflect.DeepEqual(b.config, old.config) {
+		return
+	}
+	old.mux.Lock()
+	state, since, failures, transitions := old.state, old.since, old.failures, old.transitions
+	old.mux.Unlock()
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.state, b.since, b.failures, b.transitions = state, since, failures, transitions
+	setGauge(breakerState, b.routeID, state)
+}
+
+func (b *breaker) halfOpenRequests() int {
+	if b.config.HalfOpenRequests <= 0 {
+		return defaultHalfOpenRequests
+	}
+	return b.config.HalfOpenRequests
+}
+
+// transition moves the breaker to state, it is called with the lock held
+func (b *breaker) transition(state string, now time.Time) {
+	log.Printf("Circuit breaker for route %s is %s after being %s for %v", b.routeID, state, b.state, now.Sub(b.since))
+	b.state, b.since = state, now
+	b.failures, b.trials, b.successes = 0, 0, 0
+	b.transitions++
+	setGauge(breakerState, b.routeID, state)
+	breakerTransitions.Add(b.routeID+"."+state, 1)
+}
+
+// allow reports whether a request may be forwarded, and otherwise how long
+// the client should wait before retrying
+func (b *breaker) allow() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	if b.state == types.BreakerOpen {
+		reopen := b.since.Add(b.config.OpenTimeout.Or(defaultOpenTimeout))
+		if now.Before(reopen) {
+			return false, reopen.Sub(now)
+		}
+		b.transition(types.BreakerHalfOpen, now)
+	}
+	if b.state == types.BreakerHalfOpen {
+		if b.trials >= b.halfOpenRequests() {
+			return false, time.Second
+		}
+		b.trials++
+	}
+	return true, 0
+}
+
+// record counts the result of a request that allow let through
+func (b *breaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	switch b.state {
+	case types.BreakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		threshold := b.config.ConsecutiveFailures
+		if threshold <= 0 {
+			threshold = defaultConsecutiveFailures
+		}
+		if b.failures >= threshold {
+			b.transition(types.BreakerOpen, now)
+		}
+	case types.BreakerHalfOpen:
+		if failed {
+			b.transition(types.BreakerOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests() {
+			b.transition(types.BreakerClosed, now)
+		}
+	}
+}
+
+// abandon gives back a half-open trial whose result says nothing about the upstream
+func (b *breaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.state == types.BreakerHalfOpen && b.trials > b.successes {
+		b.trials--
+	}
+}
+
+func (b *breaker) status() types.BreakerStatus {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return types.BreakerStatus{State: b.state, Since: b.since, Failures: b.failures, Transitions: b.transitions}
+}
+
+// Breakers reports the circuit breaker state of every route that configures one and has received traffic
+func (p *proxyWrapper) Breakers() map[string]types.BreakerStatus {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	breakers := make(map[string]types.BreakerStatus)
+	for id, b := range p.backends {
+		if b.breaker != nil {
+			breakers[id] = b.breaker.status()
+		}
+	}
+	return breakers
+}
@@ -0,0 +1,141 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	var failing int32 = 1
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+
+	route := types.Route{
+		ID:             "breaking",
+		ProxiedURL:     "ocelot.com",
+		Upstream:       flaky.URL,
+		CircuitBreaker: &types.CircuitBreaker{ConsecutiveFailures: 2, OpenTimeout: types.Duration(50 * time.Millisecond)},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	for i := 0; i < 2; i++ {
+		if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusInternalServerError {
+			t.Fatal("Proxy returned ", code, " instead of ", http.StatusInternalServerError)
+		}
+	}
+
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, routedRequest(t, "GET", "http://ocelot.com/", route))
+	if respRec.Code != http.StatusServiceUnavailable || respRec.Header().Get("Retry-After") != "1" {
+		t.Fatal("Open breaker returned ", respRec.Code, " with Retry-After ", respRec.Header().Get("Retry-After"), " instead of 503 with 1")
+	}
+	if status := proxy.Breakers()["breaking"]; status.State != types.BreakerOpen || status.Transitions != 1 {
+		t.Fatal("Proxy reported breaker ", status, " instead of open after one transition")
+	}
+	if state := breakerState.Get("breaking").String(); state != `"open"` {
+		t.Fatal("Breaker state metric was ", state, " instead of open")
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusOK {
+		t.Fatal("Half-open breaker returned ", code, " instead of ", http.StatusOK)
+	}
+	if status := proxy.Breakers()["breaking"]; status.State != types.BreakerClosed || status.Transitions != 3 {
+		t.Fatal("Proxy reported breaker ", status, " instead of closed after three transitions")
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	b := newBreaker("reopening", &types.CircuitBreaker{ConsecutiveFailures: 1, HalfOpenRequests: 2})
+	b.allow()
+	b.record(true)
+	b.since = time.Now().Add(-time.Hour)
+
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("Breaker rejected its first half-open trial")
+	}
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("Breaker rejected its second half-open trial")
+	}
+	if ok, retryAfter := b.allow(); ok || retryAfter <= 0 {
+		t.Fatal("Breaker allowed more half-open trials than configured")
+	}
+	b.record(false)
+	b.record(true)
+	if status := b.status(); status.State != types.BreakerOpen {
+		t.Fatal("Breaker was ", status.State, " instead of open after a failed trial")
+	}
+}
+
+func TestWebSocketRejectionKeepsHalfOpenTrial(t *testing.T) {
+	server := upstream("recovered")
+	defer server.Close()
+	route := types.Route{
+		ID:             "sockets",
+		ProxiedURL:     "ocelot.com",
+		Upstream:       server.URL,
+		CircuitBreaker: &types.CircuitBreaker{ConsecutiveFailures: 1, HalfOpenRequests: 1},
+		WebSocket:      &types.WebSocket{MaxConnections: 1},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	b := proxy.backend(&route).breaker
+	b.allow()
+	b.record(true)
+	b.since = time.Now().Add(-time.Hour)
+	atomic.StoreInt64(proxy.webSockets(route.ID), 1)
+
+	req := routedRequest(t, "GET", "http://ocelot.com/socket", route)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if code, _ := serve(proxy, req); code != http.StatusServiceUnavailable {
+		t.Fatal("WebSocket over the limit was answered ", code, " instead of ", http.StatusServiceUnavailable)
+	}
+	if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusOK {
+		t.Fatal("Half-open breaker returned ", code, " instead of ", http.StatusOK, " after a rejected WebSocket")
+	}
+}
+
+func TestBreakerKeptAcrossRouteChanges(t *testing.T) {
+	route := types.Route{
+		ID:             "changing",
+		ProxiedURL:     "ocelot.com",
+		Upstream:       "http://orders.ocelot.com",
+		CircuitBreaker: &types.CircuitBreaker{ConsecutiveFailures: 1},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	old := proxy.backend(&route)
+	old.breaker.allow()
+	old.breaker.record(true)
+
+	changed := route
+	changed.PreserveHost = true
+	b := proxy.backend(&changed)
+	if b == old {
+		t.Fatal("Backend was not rebuilt after the route changed")
+	}
+	if state := b.breaker.status().State; state != types.BreakerOpen {
+		t.Fatal("Rebuilt backend had a ", state, " breaker instead of ", types.BreakerOpen)
+	}
+	if proxy.backend(&changed) != b {
+		t.Fatal("Backend was rebuilt for an unchanged route")
+	}
+}
+
+func TestBreakerDisabledByDefault(t *testing.T) {
+	var b *breaker
+	for i := 0; i < 20; i++ {
+		b.record(true)
+	}
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("Request was rejected without a circuit breaker configured")
+	}
+}
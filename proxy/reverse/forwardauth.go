@@ -0,0 +1,108 @@
+package reverse
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const defaultForwardAuthTimeout = 5 * time.Second
+
+// hopHeaders only apply to a single connection and are not passed on, see RFC 7230
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// copyHeaders adds the end-to-end headers of src to dst
+func copyHeaders(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append(dst[name], values...)
+	}
+	for _, name := range hopHeaders {
+		dst.Del(name)
+	}
+}
+
+// forwardAuth asks an external service whether to allow the requests to a route
+type forwardAuth struct {
+	config *types.ForwardAuth
+	client *http.Client
+}
+
+// newForwardAuth returns nil when the route does not use an authorization service
+func newForwardAuth(route *types.Route) *forwardAuth {
+	if route.ForwardAuth == nil {
+		return nil
+	}
+	return &forwardAuth{
+		config: route.ForwardAuth,
+		client: &http.Client{
+			Timeout: route.ForwardAuth.Timeout.Or(defaultForwardAuthTimeout),
+			// redirects, e.g. to a login page, are for the client to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// authorize sends the request's method, URI and headers to the authorization
+// service. When it answers 2xx, the configured headers of its response are
+// copied onto the request, otherwise its response is sent to the client.
+func (f *forwardAuth) authorize(w http.ResponseWriter, req *http.Request, routeID string) bool {
+	if f == nil {
+		return true
+	}
+	// copied headers can only come from the authorization service
+	for _, header := range f.config.ResponseHeaders {
+		req.Header.Del(header)
+	}
+	check, err := http.NewRequest(req.Method, f.config.URL, nil)
+	if err != nil {
+		log.Printf("Forward auth for route %s failed: %v", routeID, err)
+		writeError(w, req, http.StatusInternalServerError, "")
+		return false
+	}
+	check = check.WithContext(req.Context())
+	copyHeaders(check.Header, req.Header)
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	check.Header.Set("X-Forwarded-Method", req.Method)
+	check.Header.Set("X-Forwarded-Proto", scheme)
+	check.Header.Set("X-Forwarded-Host", req.Host)
+	check.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+
+	resp, err := f.client.Do(check)
+	if err != nil {
+		log.Printf("Forward auth for route %s failed: %v", routeID, err)
+		forwardAuthErrors.Add(routeID, 1)
+		writeError(w, req, http.StatusBadGateway, "")
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		for _, header := range f.config.ResponseHeaders {
+			if values := resp.Header.Values(header); len(values) > 0 {
+				req.Header[http.CanonicalHeaderKey(header)] = values
+			}
+		}
+		return true
+	}
+	forwardAuthDenials.Add(routeID, 1)
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return false
+}
@@ -0,0 +1,67 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// authService allows requests carrying the session cookie, and sends the others to log in
+func authService(checked chan<- *http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checked <- r
+		if cookie, err := r.Cookie("session"); err == nil && cookie.Value == "valid" {
+			w.Header().Set("X-Auth-User", "larry")
+			w.Header().Set("X-Auth-Internal", "secret")
+			return
+		}
+		http.Redirect(w, r, "https://login.ocelot.com/", http.StatusFound)
+	}))
+}
+
+func TestForwardAuth(t *testing.T) {
+	checked := make(chan *http.Request, 2)
+	auth := authService(checked)
+	defer auth.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Auth-User") + r.Header.Get("X-Auth-Internal")))
+	}))
+	defer server.Close()
+	route := types.Route{ID: "forwarded", ProxiedURL: "ocelot.com", Upstream: server.URL, ForwardAuth: &types.ForwardAuth{
+		URL:             auth.URL + "/check",
+		ResponseHeaders: []string{"X-Auth-User"},
+	}}
+	proxy := New(nil, nil, "secret")
+
+	req := routedRequest(t, "DELETE", "http://ocelot.com/orders/1?force=true", route)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "valid"})
+	req.Header.Set("X-Auth-User", "spoofed")
+	if code, body := serve(proxy, req); code != http.StatusOK || body != "larry" {
+		t.Fatal("Allowed request was answered ", code, " with ", body, " instead of 200 with the copied user header")
+	}
+	check := <-checked
+	if check.Method != "DELETE" || check.Header.Get("X-Forwarded-Uri") != "/orders/1?force=true" || check.Header.Get("X-Forwarded-Host") != "ocelot.com" {
+		t.Fatal("Auth service received ", check.Method, " ", check.Header, " instead of the original request")
+	}
+
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, routedRequest(t, "GET", "http://ocelot.com/orders", route))
+	<-checked
+	if respRec.Code != http.StatusFound || respRec.Header().Get("Location") != "https://login.ocelot.com/" {
+		t.Fatal("Denied request was answered ", respRec.Code, " to ", respRec.Header().Get("Location"), " instead of the auth service's redirect")
+	}
+}
+
+func TestForwardAuthUnavailable(t *testing.T) {
+	auth := httptest.NewServer(http.NotFoundHandler())
+	auth.Close()
+	server := upstream("protected")
+	defer server.Close()
+	route := types.Route{ID: "unavailable", ProxiedURL: "ocelot.com", Upstream: server.URL, ForwardAuth: &types.ForwardAuth{URL: auth.URL}}
+
+	if code, _ := serve(New(nil, nil, "secret"), routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusBadGateway {
+		t.Fatal("Request with the auth service down was answered ", code, " instead of ", http.StatusBadGateway)
+	}
+}
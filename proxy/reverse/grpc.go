@@ -0,0 +1,78 @@
+package reverse
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes the proxy answers with, see
+// https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+func isGRPC(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatus maps an HTTP status to the gRPC status clients expect for it
+func grpcStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	}
+	return grpcUnknown
+}
+
+// writeGRPCStatus turns the headers into a trailers-only gRPC response carrying the status
+func writeGRPCStatus(header http.Header, status int, message string) {
+	header.Del("Content-Length")
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", strconv.Itoa(grpcStatus(status)))
+	if message != "" {
+		header.Set("Grpc-Message", message)
+	}
+}
+
+// writeError answers a request the proxy could not forward, with a gRPC
+// status for gRPC clients since they ignore HTTP errors
+func writeError(w http.ResponseWriter, req *http.Request, status int, message string) {
+	switch {
+	case isGRPC(req):
+		writeGRPCStatus(w.Header(), status, message)
+		w.WriteHeader(http.StatusOK)
+	case message == "":
+		w.WriteHeader(status)
+	default:
+		http.Error(w, message, status)
+	}
+}
+
+// grpcResponse rewrites HTTP errors from the upstream of a gRPC request, such
+// as those of a load balancer in front of it, into gRPC statuses
+func grpcResponse(resp *http.Response) {
+	if !isGRPC(resp.Request) || resp.StatusCode == http.StatusOK || resp.Header.Get("Grpc-Status") != "" {
+		return
+	}
+	writeGRPCStatus(resp.Header, resp.StatusCode, http.StatusText(resp.StatusCode))
+	resp.Body.Close()
+	resp.Body, resp.ContentLength = http.NoBody, 0
+	resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+}
@@ -0,0 +1,68 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcRequest builds a gRPC call routed to route
+func grpcRequest(t *testing.T, route types.Route) *http.Request {
+	req := routedRequest(t, "POST", "http://ocelot.com/ocelot.Greeter/Greet", route)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	return req
+}
+
+func TestH2CUpstreamKeepsTrailers(t *testing.T) {
+	greeter := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Error("Upstream received ", r.Proto, " instead of HTTP/2")
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write([]byte("greeting"))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "done")
+	}), &http2.Server{}))
+	defer greeter.Close()
+
+	route := types.Route{ID: "greeter", ProxiedURL: "ocelot.com", Upstream: greeter.URL, Protocol: types.ProtocolH2C}
+	respRec := httptest.NewRecorder()
+	New(nil, nil, "secret").ServeHTTP(respRec, grpcRequest(t, route))
+	resp := respRec.Result()
+
+	if resp.StatusCode != http.StatusOK || respRec.Body.String() != "greeting" {
+		t.Fatal("Proxy answered ", resp.StatusCode, " with ", respRec.Body.String(), " instead of 200 with greeting")
+	}
+	if status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"); status != "0" || message != "done" {
+		t.Fatal("Proxy sent trailers ", resp.Trailer, " instead of grpc-status 0 and grpc-message done")
+	}
+}
+
+func TestGRPCErrorStatuses(t *testing.T) {
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	closed := upstream("closed")
+	closed.Close()
+
+	cases := []struct {
+		upstream string
+		status   string
+	}{
+		{missing.URL, "12"},
+		{closed.URL, "14"},
+	}
+	for _, c := range cases {
+		route := types.Route{ID: "grpc", ProxiedURL: "ocelot.com", Upstream: c.upstream}
+		respRec := httptest.NewRecorder()
+		New(nil, nil, "secret").ServeHTTP(respRec, grpcRequest(t, route))
+		if respRec.Code != http.StatusOK || respRec.Header().Get("Grpc-Status") != c.status {
+			t.Fatal("Proxy answered ", respRec.Code, " with grpc-status ", respRec.Header().Get("Grpc-Status"), " instead of ", c.status)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package reverse
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultHealthInterval     = 10 * time.Second
+	defaultHealthTimeout      = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+	healthSyncInterval        = 10 * time.Second
+	healthCheckUserAgent      = "go-ocelot-health-check"
+)
+
+// Target health states, targets start out healthy
+const (
+	healthy int32 = iota
+	unhealthy
+)
+
+// Healthy reports whether the target passed its most recent health checks
+func (t *Target) Healthy() bool {
+	return atomic.LoadInt32(&t.health) == healthy
+}
+
+// recordProbe counts consecutive probe results and flips the target's health
+// once a threshold is reached. It is only called from the backend's checker.
+func (t *Target) recordProbe(ok bool, config *types.HealthCheck, routeID string) {
+	if ok {
+		t.failures = 0
+		t.successes++
+	} else {
+		t.successes = 0
+		t.failures++
+	}
+	healthyThreshold, unhealthyThreshold := config.HealthyThreshold, config.UnhealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultHealthyThreshold
+	}
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultUnhealthyThreshold
+	}
+	switch {
+	case !t.Healthy() && t.successes >= healthyThreshold:
+		atomic.StoreInt32(&t.health, healthy)
+		log.Printf("Upstream %s for route %s is healthy again", t.URL, routeID)
+	case t.Healthy() && t.failures >= unhealthyThreshold:
+		atomic.StoreInt32(&t.health, unhealthy)
+		log.Printf("Upstream %s for route %s is unhealthy after %d failed health checks", t.URL, routeID, t.failures)
+	}
+}
+
+// probe requests the health check path of a target
+func probe(client *http.Client, target *Target, path string) bool {
+	req, err := http.NewRequest("GET", singleJoiningSlash(target.URL.String(), path), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// healthCheck probes the targets of the backend until stop is closed
+func (b *backend) healthCheck(stop <-chan struct{}) {
+	config := b.route.HealthCheck
+	client := &http.Client{Timeout: config.Timeout.Or(defaultHealthTimeout)}
+	if b.transport != nil {
+		client.Transport = b.transport
+	}
+	ticker := time.NewTicker(config.Interval.Or(defaultHealthInterval))
+	defer ticker.Stop()
+	for {
+		for _, target := range b.targets {
+			target.recordProbe(probe(client, target, config.Path), config, b.route.ID)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncBackends creates backends for routes with health checks so they are
+// probed before receiving traffic, and stops those of removed routes
+func (p *proxyWrapper) syncBackends() {
+	current := p.repo.Routes()
+	for id := range current {
+		if route := current[id]; route.HealthCheck != nil {
+			p.backend(&route)
+		}
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for id, b := range p.backends {
+		if _, ok := current[id]; !ok {
+			b.stop()
+			delete(p.backends, id)
+			breakerState.Delete(id)
+		}
+	}
+}
+
+// Start begins health checking the upstreams of every route that configures it
+func (p *proxyWrapper) Start() {
+	go func() {
+		p.syncBackends()
+		for range time.Tick(healthSyncInterval) {
+			p.syncBackends()
+		}
+	}()
+}
+
+// Upstreams reports the state of the targets of every route that has received traffic or is health checked
+func (p *proxyWrapper) Upstreams() map[string][]types.TargetStatus {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	upstreams := make(map[string][]types.TargetStatus)
+	for id, b := range p.backends {
+		statuses := []types.TargetStatus{}
+		for _, target := range b.targets {
+			status := types.TargetStatus{
+				URL:     target.URL.String(),
+				Version: target.version,
+				Weight:  target.Weight,
+				Healthy: target.Healthy(),
+				Active:  target.Active(),
+				Ejected: target.Ejected(),
+			}
+			target.outlier.mux.Lock()
+			status.Ejections = target.outlier.ejections
+			target.outlier.mux.Unlock()
+			if status.Ejected {
+				until := time.Unix(0, atomic.LoadInt64(&target.outlier.ejectedUntil))
+				status.EjectedUntil = &until
+			}
+			statuses = append(statuses, status)
+		}
+		upstreams[id] = statuses
+	}
+	return upstreams
+}
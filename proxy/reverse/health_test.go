@@ -0,0 +1,79 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// waitFor polls condition until it holds or a second has passed
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return condition()
+}
+
+func TestHealthCheckMarksTargets(t *testing.T) {
+	var failing int32
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Error("Health check requested ", r.URL.Path, " instead of /healthz")
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer sick.Close()
+	well := upstream("well")
+	defer well.Close()
+
+	b := newBackend(types.Route{
+		ID:      "checked",
+		Targets: []types.Target{{URL: sick.URL}, {URL: well.URL}},
+		HealthCheck: &types.HealthCheck{
+			Path:               "/healthz",
+			Interval:           types.Duration(5 * time.Millisecond),
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+		},
+	})
+	defer b.stop()
+
+	atomic.StoreInt32(&failing, 1)
+	if !waitFor(func() bool { return !b.targets[0].Healthy() }) {
+		t.Fatal("Failing target was not marked unhealthy")
+	}
+	if !b.targets[1].Healthy() {
+		t.Fatal("Passing target was marked unhealthy")
+	}
+	for i := 0; i < 4; i++ {
+		if target := b.balancer.Next(httptest.NewRequest("GET", "/", nil)); target != b.targets[1] {
+			t.Fatal("Balancer picked unhealthy target ", target.URL)
+		}
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	if !waitFor(func() bool { return b.targets[0].Healthy() }) {
+		t.Fatal("Recovered target was not marked healthy")
+	}
+}
+
+func TestProxyWithoutHealthyTargets(t *testing.T) {
+	route := types.Route{ID: "down", ProxiedURL: "ocelot.com", Upstream: "http://down:8080"}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	atomic.StoreInt32(&proxy.backend(&route).targets[0].health, unhealthy)
+
+	if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusServiceUnavailable {
+		t.Fatal("Proxy returned ", code, " instead of ", http.StatusServiceUnavailable)
+	}
+	if status := proxy.Upstreams()["down"]; len(status) != 1 || status[0].Healthy {
+		t.Fatal("Proxy reported upstreams ", status, " instead of one unhealthy target")
+	}
+}
@@ -0,0 +1,373 @@
+package reverse
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultJWKSRefresh = 10 * time.Minute
+	// tokens naming unknown keys refetch the JWKS at most this often
+	jwksMinRefresh = time.Minute
+	jwksTimeout    = 5 * time.Second
+	jwtLeeway      = 30 * time.Second
+)
+
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jsonWebKey is the subset of RFC 7517 needed for signature verification keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// publicKey decodes an RSA, elliptic curve or Ed25519 key
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBase64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decodeBase64(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Unsupported OKP key %s", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %s", k.Kty)
+}
+
+// parseKeys reads the verification keys of a JWKS document or of PEM public
+// keys and certificates, keyed by ID. Keys without an ID are keyed by "".
+func parseKeys(data []byte) (map[string][]crypto.PublicKey, error) {
+	keys := make(map[string][]crypto.PublicKey)
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		if err := json.Unmarshal(data, &set); err != nil {
+			return nil, err
+		}
+		for _, k := range set.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			key, err := k.publicKey()
+			if err != nil {
+				log.Printf("Skipping JWKS key %s: %v", k.Kid, err)
+				continue
+			}
+			keys[k.Kid] = append(keys[k.Kid], key)
+		}
+	} else {
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			switch block.Type {
+			case "PUBLIC KEY":
+				key, err := x509.ParsePKIXPublicKey(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				keys[""] = append(keys[""], key)
+			case "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				keys[""] = append(keys[""], cert.PublicKey)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No verification keys found")
+	}
+	return keys, nil
+}
+
+// keySet holds the keys tokens of a route may be signed with, fetching them
+// from a JWKS URL when the route gives one
+type keySet struct {
+	mux      sync.Mutex
+	url      string
+	refresh  time.Duration
+	client   *http.Client
+	keys     map[string][]crypto.PublicKey
+	fetched  time.Time
+	attempts time.Time
+	// fetching is closed once the fetch in progress, if any, is done
+	fetching chan struct{}
+}
+
+// download reads the keys published at the JWKS URL
+func (s *keySet) download() (map[string][]crypto.PublicKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS %s answered %d", s.url, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(data)
+}
+
+// fetch replaces the keys with those downloaded, without holding the lock
+// while downloading so that requests with known keys are not held up
+func (s *keySet) fetch(done chan struct{}) {
+	keys, err := s.download()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err != nil {
+		log.Printf("Fetching JWKS %s failed: %v", s.url, err)
+	} else {
+		s.keys, s.fetched = keys, time.Now()
+	}
+	s.fetching = nil
+	close(done)
+}
+
+// lookup returns the keys with an ID, or every key when the ID is empty. Stale
+// keys are refreshed in the background, only requests for missing keys wait.
+func (s *keySet) lookup(kid string) []crypto.PublicKey {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.url != "" {
+		_, known := s.keys[kid]
+		if kid == "" {
+			known = len(s.keys) > 0
+		}
+		stale := time.Since(s.fetched) > s.refresh
+		if s.fetching == nil && (stale || (kid != "" && !known)) && time.Since(s.attempts) > jwksMinRefresh {
+			s.attempts = time.Now()
+			s.fetching = make(chan struct{})
+			go s.fetch(s.fetching)
+		}
+		if fetching := s.fetching; fetching != nil && !known {
+			s.mux.Unlock()
+			<-fetching
+			s.mux.Lock()
+		}
+	}
+	if kid != "" {
+		return s.keys[kid]
+	}
+	var all []crypto.PublicKey
+	for _, keys := range s.keys {
+		all = append(all, keys...)
+	}
+	return all
+}
+
+// jwtValidator checks the bearer tokens of requests to a route
+type jwtValidator struct {
+	config *types.JWT
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+// newJWTValidator returns nil when the route does not require tokens
+func newJWTValidator(route *types.Route) *jwtValidator {
+	config := route.JWT
+	if config == nil {
+		return nil
+	}
+	keys := &keySet{
+		url:     config.JWKSURL,
+		refresh: config.RefreshInterval.Or(defaultJWKSRefresh),
+		client:  &http.Client{Timeout: jwksTimeout},
+		keys:    make(map[string][]crypto.PublicKey),
+	}
+	if config.KeysFile != "" {
+		data, err := ioutil.ReadFile(config.KeysFile)
+		if err == nil {
+			keys.keys, err = parseKeys(data)
+		}
+		if err != nil {
+			// tokens are rejected until the route is fixed
+			log.Printf("Reading JWT keys for route %s failed: %v", route.ID, err)
+			keys.keys = make(map[string][]crypto.PublicKey)
+		}
+	}
+	return &jwtValidator{
+		config: config,
+		keys:   keys,
+		parser: jwt.NewParser(jwt.WithValidMethods(jwtMethods), jwt.WithLeeway(jwtLeeway),
+			jwt.WithExpirationRequired(), jwt.WithIssuedAt()),
+	}
+}
+
+// bearerToken returns the token of an Authorization header
+func bearerToken(req *http.Request) string {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// claimString renders a claim as a header value, joining lists with commas
+func claimString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = claimString(v[i])
+		}
+		return strings.Join(values, ",")
+	case float64:
+		return big.NewFloat(v).Text('f', -1)
+	}
+	return fmt.Sprint(value)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClaim reports whether a claim is the value, or a list containing it
+func hasClaim(claim interface{}, value string) bool {
+	if values, ok := claim.([]interface{}); ok {
+		for _, v := range values {
+			if claimString(v) == value {
+				return true
+			}
+		}
+		return false
+	}
+	return claim != nil && claimString(claim) == value
+}
+
+// validate returns the claims of the request's token, or why it is not acceptable
+func (v *jwtValidator) validate(req *http.Request) (jwt.MapClaims, error) {
+	raw := bearerToken(req)
+	if raw == "" {
+		return nil, fmt.Errorf("Missing bearer token")
+	}
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		keys := v.keys.lookup(kid)
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("Unknown signing key %s", kid)
+		}
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(v.config.Issuers) > 0 && !containsString(v.config.Issuers, claimString(claims["iss"])) {
+		return nil, fmt.Errorf("Untrusted issuer %v", claims["iss"])
+	}
+	if len(v.config.Audiences) > 0 {
+		audiences, _ := claims.GetAudience()
+		accepted := false
+		for _, audience := range audiences {
+			accepted = accepted || containsString(v.config.Audiences, audience)
+		}
+		if !accepted {
+			return nil, fmt.Errorf("Token is not for an accepted audience")
+		}
+	}
+	for name, value := range v.config.Claims {
+		if !hasClaim(claims[name], value) {
+			return nil, fmt.Errorf("Claim %s must be %s", name, value)
+		}
+	}
+	return claims, nil
+}
+
+// authenticate validates the request's token, forwarding the configured
+// claims as headers, and answers 401 when it is not acceptable
+func (v *jwtValidator) authenticate(w http.ResponseWriter, req *http.Request, routeID string) bool {
+	if v == nil {
+		return true
+	}
+	// forwarded claim headers can only come from the proxy
+	for _, header := range v.config.ForwardClaims {
+		req.Header.Del(header)
+	}
+	claims, err := v.validate(req)
+	if err != nil {
+		log.Printf("Rejecting token for route %s: %v", routeID, err)
+		jwtRejections.Add(routeID, 1)
+		challenge := "Bearer"
+		if bearerToken(req) != "" {
+			challenge = `Bearer error="invalid_token"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		writeError(w, req, http.StatusUnauthorized, "Invalid token")
+		return false
+	}
+	for claim, header := range v.config.ForwardClaims {
+		if value := claimString(claims[claim]); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	return true
+}
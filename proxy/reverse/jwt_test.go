@@ -0,0 +1,214 @@
+package reverse
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("Generating key failed: ", err)
+	}
+	return key
+}
+
+// jwksServer publishes the public half of keys under their IDs
+func jwksServer(keys map[string]*rsa.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal("Signing token failed: ", err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   "https://issuer.ocelot.com",
+		"aud":   []string{"orders"},
+		"sub":   "larry",
+		"scope": []string{"read", "write"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// claimsUpstream answers with the subject header it received
+func claimsUpstream() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-User")))
+	}))
+}
+
+func authorizedRequest(t *testing.T, route types.Route, token string) *http.Request {
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.Header.Set("X-User", "spoofed")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestJWTValidatesTokensAgainstJWKS(t *testing.T) {
+	key, other := rsaKey(t), rsaKey(t)
+	jwks := jwksServer(map[string]*rsa.PrivateKey{"current": key})
+	defer jwks.Close()
+	server := claimsUpstream()
+	defer server.Close()
+	route := types.Route{ID: "secured", ProxiedURL: "ocelot.com", Upstream: server.URL, JWT: &types.JWT{
+		Issuers:       []string{"https://issuer.ocelot.com"},
+		Audiences:     []string{"orders"},
+		JWKSURL:       jwks.URL,
+		Claims:        map[string]string{"scope": "write"},
+		ForwardClaims: map[string]string{"sub": "X-User"},
+	}}
+	proxy := New(nil, nil, "secret")
+
+	if code, body := serve(proxy, authorizedRequest(t, route, signToken(t, key, "current", validClaims()))); code != http.StatusOK || body != "larry" {
+		t.Fatal("Valid token was answered ", code, " with ", body, " instead of 200 with the forwarded subject")
+	}
+
+	wrongIssuer, wrongAudience, missingScope, expired := validClaims(), validClaims(), validClaims(), validClaims()
+	wrongIssuer["iss"] = "https://evil.com"
+	wrongAudience["aud"] = "billing"
+	missingScope["scope"] = "read"
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	unexpiring, issuedLater := validClaims(), validClaims()
+	delete(unexpiring, "exp")
+	issuedLater["iat"] = time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"missing", ""},
+		{"wrong issuer", signToken(t, key, "current", wrongIssuer)},
+		{"wrong audience", signToken(t, key, "current", wrongAudience)},
+		{"missing scope", signToken(t, key, "current", missingScope)},
+		{"expired", signToken(t, key, "current", expired)},
+		{"without expiry", signToken(t, key, "current", unexpiring)},
+		{"issued in the future", signToken(t, key, "current", issuedLater)},
+		{"forged", signToken(t, other, "current", validClaims())},
+		{"unknown key", signToken(t, other, "other", validClaims())},
+	}
+	for _, test := range tests {
+		respRec := httptest.NewRecorder()
+		proxy.ServeHTTP(respRec, authorizedRequest(t, route, test.token))
+		if respRec.Code != http.StatusUnauthorized || respRec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatal("Token ", test.name, " was answered ", respRec.Code, " instead of ", http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestJWTRefetchesRotatedKeys(t *testing.T) {
+	old, rotated := rsaKey(t), rsaKey(t)
+	keys := map[string]*rsa.PrivateKey{"old": old}
+	jwks := jwksServer(keys)
+	defer jwks.Close()
+	server := claimsUpstream()
+	defer server.Close()
+	route := types.Route{ID: "rotated", ProxiedURL: "ocelot.com", Upstream: server.URL, JWT: &types.JWT{JWKSURL: jwks.URL}}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+
+	if code, _ := serve(proxy, authorizedRequest(t, route, signToken(t, old, "old", validClaims()))); code != http.StatusOK {
+		t.Fatal("Token signed with the published key was answered ", code, " instead of ", http.StatusOK)
+	}
+	keys["rotated"] = rotated
+	// let the next unknown key refetch the JWKS
+	proxy.backend(&route).auth.keys.attempts = time.Time{}
+	if code, _ := serve(proxy, authorizedRequest(t, route, signToken(t, rotated, "rotated", validClaims()))); code != http.StatusOK {
+		t.Fatal("Token signed with a rotated key was answered ", code, " instead of ", http.StatusOK)
+	}
+}
+
+func TestJWTRefreshDoesNotHoldUpRequests(t *testing.T) {
+	key := rsaKey(t)
+	published := jwksServer(map[string]*rsa.PrivateKey{"current": key})
+	defer published.Close()
+	release := make(chan struct{})
+	fetches := make(chan struct{}, 2)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches <- struct{}{}
+		if len(fetches) > 1 {
+			// the refresh hangs until the test is done
+			<-release
+		}
+		published.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer jwks.Close()
+	defer close(release)
+	server := claimsUpstream()
+	defer server.Close()
+	route := types.Route{ID: "refreshed", ProxiedURL: "ocelot.com", Upstream: server.URL, JWT: &types.JWT{JWKSURL: jwks.URL}}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	token := signToken(t, key, "current", validClaims())
+
+	if code, _ := serve(proxy, authorizedRequest(t, route, token)); code != http.StatusOK {
+		t.Fatal("Token signed with the published key was answered ", code, " instead of ", http.StatusOK)
+	}
+	keys := proxy.backend(&route).auth.keys
+	keys.mux.Lock()
+	keys.fetched, keys.attempts = time.Time{}, time.Time{}
+	keys.mux.Unlock()
+	started := time.Now()
+	if code, _ := serve(proxy, authorizedRequest(t, route, token)); code != http.StatusOK || time.Since(started) > time.Second {
+		t.Fatal("Token was answered ", code, " after ", time.Since(started), " while the stale keys were refreshed")
+	}
+}
+
+func TestJWTKeysFromFile(t *testing.T) {
+	key := rsaKey(t)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	file, err := ioutil.TempFile("", "jwt-keys")
+	if err != nil {
+		t.Fatal("Creating key file failed: ", err)
+	}
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	file.Close()
+	server := claimsUpstream()
+	defer server.Close()
+	route := types.Route{ID: "file", ProxiedURL: "ocelot.com", Upstream: server.URL, JWT: &types.JWT{KeysFile: file.Name()}}
+	proxy := New(nil, nil, "secret")
+
+	if code, _ := serve(proxy, authorizedRequest(t, route, signToken(t, key, "", validClaims()))); code != http.StatusOK {
+		t.Fatal("Token signed with the key from the file was answered ", code, " instead of ", http.StatusOK)
+	}
+	if code, _ := serve(proxy, authorizedRequest(t, route, signToken(t, rsaKey(t), "", validClaims()))); code != http.StatusUnauthorized {
+		t.Fatal("Token signed with another key was answered ", code, " instead of ", http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,38 @@
+package reverse
+
+import "expvar"
+
+// Proxy metrics are published with expvar, keyed by route ID
+var (
+	breakerState         = expvar.NewMap("breaker_state")
+	breakerTransitions   = expvar.NewMap("breaker_transitions")
+	breakerRejections    = expvar.NewMap("breaker_rejections")
+	retries              = expvar.NewMap("retries")
+	retryBudgetExhausted = expvar.NewMap("retry_budget_exhausted")
+	upstreamTimeouts     = expvar.NewMap("upstream_timeouts")
+	// mirrored status pairs are keyed route.primary.shadow, latencies add up milliseconds
+	mirrorRequests       = expvar.NewMap("mirror_requests")
+	mirrorSkipped        = expvar.NewMap("mirror_skipped")
+	mirrorStatus         = expvar.NewMap("mirror_status")
+	mirrorMismatches     = expvar.NewMap("mirror_status_mismatches")
+	mirrorPrimaryLatency = expvar.NewMap("mirror_primary_latency_ms")
+	mirrorShadowLatency  = expvar.NewMap("mirror_shadow_latency_ms")
+	webSocketConnections = expvar.NewMap("websocket_connections")
+	webSocketRejections  = expvar.NewMap("websocket_rejections")
+	webSocketIdleClosed  = expvar.NewMap("websocket_idle_closed")
+	cacheHits            = expvar.NewMap("cache_hits")
+	cacheMisses          = expvar.NewMap("cache_misses")
+	cacheRevalidations   = expvar.NewMap("cache_revalidations")
+	rateLimited          = expvar.NewMap("rate_limited")
+	jwtRejections        = expvar.NewMap("jwt_rejections")
+	authRejections       = expvar.NewMap("auth_rejections")
+	forwardAuthDenials   = expvar.NewMap("forward_auth_denials")
+	forwardAuthErrors    = expvar.NewMap("forward_auth_errors")
+)
+
+// setGauge stores a string value in a metrics map
+func setGauge(m *expvar.Map, key, value string) {
+	v := new(expvar.String)
+	v.Set(value)
+	m.Set(key, v)
+}
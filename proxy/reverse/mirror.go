@@ -0,0 +1,193 @@
+package reverse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultMirrorPercent     = 100
+	defaultMirrorMaxBodySize = 64 << 10
+	defaultMirrorTimeout     = 10 * time.Second
+	defaultMirrorMaxInFlight = 100
+)
+
+// mirror shadows a share of a route's requests to another upstream
+type mirror struct {
+	config  *types.Mirror
+	routeID string
+	target  *Target
+	client  *http.Client
+	// inFlight holds a token for each pending shadow request
+	inFlight chan struct{}
+}
+
+// newMirror returns nil when the route does not mirror its requests
+func newMirror(route *types.Route, transport http.RoundTripper) *mirror {
+	if route.Mirror == nil {
+		return nil
+	}
+	upstream, err := routes.ParseUpstream(route.Mirror.Upstream)
+	if err != nil {
+		log.Printf("Not mirroring route %s: %v", route.ID, err)
+		return nil
+	}
+	client := &http.Client{
+		Timeout: route.Mirror.Timeout.Or(defaultMirrorTimeout),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if transport != nil {
+		client.Transport = transport
+	}
+	maxInFlight := route.Mirror.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMirrorMaxInFlight
+	}
+	return &mirror{
+		config:   route.Mirror,
+		routeID:  route.ID,
+		target:   &Target{URL: upstream, Weight: 1, routeID: route.ID},
+		client:   client,
+		inFlight: make(chan struct{}, maxInFlight),
+	}
+}
+
+// sampled reports whether the request falls in the mirrored share of traffic
+func (m *mirror) sampled(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	percent := defaultMirrorPercent
+	if m.config.Percent != nil {
+		percent = *m.config.Percent
+	}
+	return rand.Intn(100) < percent
+}
+
+// bufferBody reads up to max bytes of the request body so it can be sent
+// twice, leaving the request able to read its whole body. It returns false
+// when the body is larger than max.
+func bufferBody(req *http.Request, max int64) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+	return body, err == nil && int64(len(body)) <= max
+}
+
+type primaryResult struct {
+	status  int
+	latency time.Duration
+}
+
+// shadow starts mirroring the request when it is sampled. It returns the
+// writer the response to the client must go through, and a function to call
+// once that response has been sent.
+func (m *mirror) shadow(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, func()) {
+	if m == nil || !m.sampled(req) {
+		return w, func() {}
+	}
+	max := m.config.MaxBodySize
+	if max <= 0 {
+		max = defaultMirrorMaxBodySize
+	}
+	body, ok := bufferBody(req, max)
+	if !ok {
+		mirrorSkipped.Add(m.routeID, 1)
+		return w, func() {}
+	}
+	// a slow shadow must not pile up requests and their bodies
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		mirrorSkipped.Add(m.routeID, 1)
+		return w, func() {}
+	}
+
+	// the shadow request is directed like the real one, but must outlive it
+	ctx := newTargetContext(routes.NewContext(context.Background(), routes.FromContext(req.Context())), m.target)
+	out := req.Clone(ctx)
+	out.RequestURI = ""
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	director(out)
+
+	primary := make(chan primaryResult, 1)
+	go m.send(out, primary)
+	recorder, start := &statusWriter{ResponseWriter: w}, time.Now()
+	return recorder, func() {
+		primary <- primaryResult{status: recorder.status, latency: time.Since(start)}
+	}
+}
+
+// send makes the shadow request and compares its outcome to the response the client got
+func (m *mirror) send(req *http.Request, primary <-chan primaryResult) {
+	defer func() { <-m.inFlight }()
+	start := time.Now()
+	status := 0
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("Mirroring request for route %s failed: %v", m.routeID, err)
+	} else {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		status = resp.StatusCode
+	}
+	latency := time.Since(start)
+
+	result := <-primary
+	mirrorRequests.Add(m.routeID, 1)
+	mirrorStatus.Add(fmt.Sprintf("%s.%d.%d", m.routeID, result.status, status), 1)
+	if result.status != status {
+		mirrorMismatches.Add(m.routeID, 1)
+	}
+	mirrorPrimaryLatency.AddFloat(m.routeID, result.latency.Seconds()*1000)
+	mirrorShadowLatency.AddFloat(m.routeID, latency.Seconds()*1000)
+}
+
+// statusWriter remembers the status of the response written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streamed responses through
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
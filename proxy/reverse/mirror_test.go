@@ -0,0 +1,120 @@
+package reverse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func TestMirrorShadowsRequests(t *testing.T) {
+	primary := upstream("primary")
+	defer primary.Close()
+	shadowed := make(chan string, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		shadowed <- r.Method + " " + r.URL.Path + " " + string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	route := types.Route{
+		ID:         "mirrored",
+		ProxiedURL: "ocelot.com/orders",
+		Upstream:   primary.URL,
+		Mirror:     &types.Mirror{Upstream: shadow.URL},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	mirrorMismatches.Delete("mirrored")
+	req := routedRequest(t, "POST", "http://ocelot.com/orders", route)
+	req.Body, req.ContentLength = ioutil.NopCloser(strings.NewReader("order")), 5
+
+	if code, body := serve(proxy, req); code != http.StatusOK || body != "primary" {
+		t.Fatal("Proxy answered ", code, " with ", body, " instead of 200 from primary")
+	}
+	if request := <-shadowed; request != "POST /orders order" {
+		t.Fatal("Shadow received ", request, " instead of POST /orders order")
+	}
+	if !waitFor(func() bool { return mirrorMismatches.Get("mirrored") != nil }) {
+		t.Fatal("Status mismatch between primary and shadow was not counted")
+	}
+	if count := mirrorStatus.Get("mirrored.200.500"); count == nil {
+		t.Fatal("Status pair 200/500 was not counted")
+	}
+}
+
+func TestMirrorPaused(t *testing.T) {
+	paused := 0
+	route := types.Route{
+		ID:         "paused",
+		ProxiedURL: "ocelot.com",
+		Upstream:   "http://orders.ocelot.com",
+		Mirror:     &types.Mirror{Upstream: "http://shadow.ocelot.com", Percent: &paused},
+	}
+	m := newMirror(&route, nil)
+	for i := 0; i < 100; i++ {
+		if m.sampled(routedRequest(t, "GET", "http://ocelot.com/", route)) {
+			t.Fatal("Request was mirrored while mirroring was paused")
+		}
+	}
+}
+
+func TestMirrorSkipsLargeBodies(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer primary.Close()
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Shadow received a request with a body over the limit")
+	}))
+	defer shadow.Close()
+
+	route := types.Route{
+		ID:         "capped",
+		ProxiedURL: "ocelot.com",
+		Upstream:   primary.URL,
+		Mirror:     &types.Mirror{Upstream: shadow.URL, MaxBodySize: 4},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	req := routedRequest(t, "POST", "http://ocelot.com/", route)
+	req.Body, req.ContentLength = ioutil.NopCloser(strings.NewReader("too large")), -1
+
+	if code, body := serve(proxy, req); code != http.StatusOK || body != "too large" {
+		t.Fatal("Proxy answered ", code, " with ", body, " instead of echoing the whole body")
+	}
+}
+
+func TestMirrorLimitsRequestsInFlight(t *testing.T) {
+	primary := upstream("primary")
+	defer primary.Close()
+	release := make(chan struct{})
+	shadowed := make(chan struct{}, 2)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowed <- struct{}{}
+		<-release
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	route := types.Route{
+		ID:         "slow-shadow",
+		ProxiedURL: "ocelot.com",
+		Upstream:   primary.URL,
+		Mirror:     &types.Mirror{Upstream: shadow.URL, MaxInFlight: 1},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	mirrorSkipped.Delete("slow-shadow")
+	for i := 0; i < 2; i++ {
+		if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusOK {
+			t.Fatal("Proxy answered ", code, " instead of ", http.StatusOK)
+		}
+	}
+	<-shadowed
+	if skipped := mirrorSkipped.Get("slow-shadow"); skipped == nil || skipped.String() != "1" {
+		t.Fatal("Skipped ", skipped, " mirrored requests instead of 1 while the shadow was busy")
+	}
+}
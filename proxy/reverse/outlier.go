@@ -0,0 +1,134 @@
+package reverse
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultConsecutiveErrors = 5
+	defaultErrorRatio        = 0.5
+	defaultMinRequests       = 10
+	defaultOutlierInterval   = 10 * time.Second
+	defaultBaseEjectionTime  = 30 * time.Second
+	defaultMaxEjectionTime   = 5 * time.Minute
+)
+
+// outlierState follows the results of real requests to a target so that
+// misbehaving targets can be ejected without waiting for health checks
+type outlierState struct {
+	config            *types.OutlierDetection
+	mux               sync.Mutex
+	consecutiveErrors int
+	requests, errors  int
+	windowStart       time.Time
+	ejections         int
+	// ejectedUntil is read without the lock when balancing, in unix nanoseconds
+	ejectedUntil int64
+}
+
+// inherit takes over the error counts and ejection of a target kept across a
+// change to the route, unless its outlier detection configuration changed
+func (o *outlierState) inherit(old *outlierState) {
+	if o.config == nil || !reflect.DeepEqual(o.config, old.config) {
+		return
+	}
+	old.mux.Lock()
+	o.mux.Lock()
+	o.consecutiveErrors, o.requests, o.errors = old.consecutiveErrors, old.requests, old.errors
+	o.windowStart, o.ejections = old.windowStart, old.ejections
+	o.mux.Unlock()
+	old.mux.Unlock()
+	atomic.StoreInt64(&o.ejectedUntil, atomic.LoadInt64(&old.ejectedUntil))
+}
+
+// Ejected reports whether passive outlier detection has taken the target out of rotation
+func (t *Target) Ejected() bool {
+	until := atomic.LoadInt64(&t.outlier.ejectedUntil)
+	if until == 0 {
+		return false
+	}
+	if time.Now().UnixNano() < until {
+		return true
+	}
+	if atomic.CompareAndSwapInt64(&t.outlier.ejectedUntil, until, 0) {
+		log.Printf("Upstream %s for route %s reinstated after ejection", t.URL, t.routeID)
+	}
+	return false
+}
+
+// recordResult feeds the outcome of a proxied request into outlier detection,
+// failed is set for connection errors and timeouts
+func (t *Target) recordResult(status int, failed bool) {
+	config := t.outlier.config
+	if config == nil {
+		return
+	}
+	state := &t.outlier
+	state.mux.Lock()
+	defer state.mux.Unlock()
+
+	now := time.Now()
+	interval := config.Interval.Or(defaultOutlierInterval)
+	if now.Sub(state.windowStart) > interval {
+		// Targets that make it through a whole window without ejection are slowly forgiven
+		if state.ejections > 0 && atomic.LoadInt64(&state.ejectedUntil) == 0 && state.requests > 0 {
+			state.ejections--
+		}
+		state.windowStart, state.requests, state.errors = now, 0, 0
+	}
+	state.requests++
+	if failed {
+		state.consecutiveErrors++
+	} else {
+		state.consecutiveErrors = 0
+	}
+	if failed || status >= http.StatusInternalServerError {
+		state.errors++
+	}
+
+	consecutiveErrors := config.ConsecutiveErrors
+	if consecutiveErrors <= 0 {
+		consecutiveErrors = defaultConsecutiveErrors
+	}
+	errorRatio := config.ErrorRatio
+	if errorRatio <= 0 {
+		errorRatio = defaultErrorRatio
+	}
+	minRequests := config.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultMinRequests
+	}
+	switch {
+	case state.consecutiveErrors >= consecutiveErrors:
+		t.eject(now, "%d consecutive errors", state.consecutiveErrors)
+	case state.requests >= minRequests && float64(state.errors)/float64(state.requests) >= errorRatio:
+		t.eject(now, "%d errors in %d requests", state.errors, state.requests)
+	}
+}
+
+// eject takes the target out of rotation for a time that doubles with every
+// ejection, up to the configured maximum. It is called with the lock held.
+func (t *Target) eject(now time.Time, reason string, args ...interface{}) {
+	state := &t.outlier
+	if atomic.LoadInt64(&state.ejectedUntil) != 0 {
+		return
+	}
+	duration := state.config.BaseEjectionTime.Or(defaultBaseEjectionTime)
+	for i := 0; i < state.ejections; i++ {
+		duration *= 2
+	}
+	if max := state.config.MaxEjectionTime.Or(defaultMaxEjectionTime); duration > max || duration <= 0 {
+		duration = max
+	}
+	state.ejections++
+	state.consecutiveErrors, state.requests, state.errors = 0, 0, 0
+	atomic.StoreInt64(&state.ejectedUntil, now.Add(duration).UnixNano())
+	log.Printf("Ejecting upstream %s for route %s for %v after "+reason, append([]interface{}{t.URL, t.routeID, duration}, args...)...)
+}
@@ -0,0 +1,95 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func TestOutlierEjectsFailingTarget(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	well := upstream("well")
+	defer well.Close()
+
+	route := types.Route{
+		ID:         "outliers",
+		ProxiedURL: "ocelot.com",
+		Targets:    []types.Target{{URL: broken.URL}, {URL: well.URL}},
+		OutlierDetection: &types.OutlierDetection{
+			ErrorRatio:       0.5,
+			MinRequests:      2,
+			BaseEjectionTime: types.Duration(time.Hour),
+		},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	for i := 0; i < 4; i++ {
+		serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route))
+	}
+	if !proxy.backend(&route).targets[0].Ejected() {
+		t.Fatal("Target answering with errors was not ejected")
+	}
+	for i := 0; i < 4; i++ {
+		if code, body := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusOK || body != "well" {
+			t.Fatal("Proxy answered ", code, " from ", body, " instead of 200 from well")
+		}
+	}
+	if status := proxy.Upstreams()["outliers"]; !status[0].Ejected || status[0].Ejections != 1 || status[0].EjectedUntil == nil || status[1].Ejected {
+		t.Fatal("Proxy reported upstreams ", status, " instead of the first target ejected")
+	}
+}
+
+func TestOutlierEjectsUnreachableTarget(t *testing.T) {
+	closed := upstream("closed")
+	closed.Close()
+	route := types.Route{
+		ID:               "unreachable",
+		ProxiedURL:       "ocelot.com",
+		Upstream:         closed.URL,
+		OutlierDetection: &types.OutlierDetection{ConsecutiveErrors: 2, MinRequests: 100},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	for i := 0; i < 2; i++ {
+		if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusBadGateway {
+			t.Fatal("Proxy returned ", code, " instead of ", http.StatusBadGateway)
+		}
+	}
+	if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusServiceUnavailable {
+		t.Fatal("Proxy returned ", code, " instead of ", http.StatusServiceUnavailable, " with its only target ejected")
+	}
+}
+
+func TestOutlierEjectionBacksOff(t *testing.T) {
+	target := &Target{outlier: outlierState{config: &types.OutlierDetection{
+		ConsecutiveErrors: 1,
+		BaseEjectionTime:  types.Duration(time.Millisecond),
+		MaxEjectionTime:   types.Duration(3 * time.Millisecond),
+	}}}
+	durations := []time.Duration{}
+	for i := 0; i < 3; i++ {
+		before := time.Now()
+		target.recordResult(0, true)
+		durations = append(durations, time.Unix(0, target.outlier.ejectedUntil).Sub(before).Round(time.Millisecond))
+		if !waitFor(func() bool { return !target.Ejected() }) {
+			t.Fatal("Target was not reinstated after its ejection")
+		}
+	}
+	if durations[0] != time.Millisecond || durations[1] != 2*time.Millisecond || durations[2] != 3*time.Millisecond {
+		t.Fatal("Target was ejected for ", durations, " instead of [1ms 2ms 3ms]")
+	}
+}
+
+func TestOutlierDisabledByDefault(t *testing.T) {
+	target := &Target{}
+	for i := 0; i < 20; i++ {
+		target.recordResult(0, true)
+	}
+	if target.Ejected() {
+		t.Fatal("Target was ejected without outlier detection configured")
+	}
+}
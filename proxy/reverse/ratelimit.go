@@ -0,0 +1,167 @@
+package reverse
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/cache"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultRateLimitWindow = time.Minute
+	redisRateLimitPrefix   = "go-ocelot:ratelimit:"
+	counterSweepInterval   = time.Minute
+)
+
+// counterStore keeps the request counts of rate limit windows
+type counterStore interface {
+	increment(key string, ttl time.Duration) (int64, error)
+	count(key string) (int64, error)
+}
+
+// memoryCounters limits requests to a single proxy, when there is no redis to share counts through
+type memoryCounters struct {
+	mux       sync.Mutex
+	counters  map[string]*counter
+	nextSweep time.Time
+}
+
+type counter struct {
+	value   int64
+	expires time.Time
+}
+
+func newMemoryCounters() *memoryCounters {
+	return &memoryCounters{counters: make(map[string]*counter)}
+}
+
+func (s *memoryCounters) increment(key string, ttl time.Duration) (int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for k, c := range s.counters {
+			if now.After(c.expires) {
+				delete(s.counters, k)
+			}
+		}
+		s.nextSweep = now.Add(counterSweepInterval)
+	}
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &counter{expires: now.Add(ttl)}
+		s.counters[key] = c
+	}
+	c.value++
+	return c.value, nil
+}
+
+func (s *memoryCounters) count(key string) (int64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if c, ok := s.counters[key]; ok && time.Now().Before(c.expires) {
+		return c.value, nil
+	}
+	return 0, nil
+}
+
+// redisCounters shares counts between every proxy using the same redis
+type redisCounters struct {
+	cache cache.Cache
+}
+
+func (s *redisCounters) increment(key string, ttl time.Duration) (int64, error) {
+	return s.cache.Increment(redisRateLimitPrefix+key, ttl)
+}
+
+func (s *redisCounters) count(key string) (int64, error) {
+	value, err := s.cache.Get(redisRateLimitPrefix + key)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+func newCounterStore(c cache.Cache) counterStore {
+	if c == nil {
+		return newMemoryCounters()
+	}
+	return &redisCounters{cache: c}
+}
+
+// rateLimitClient identifies the client a request is counted against, by ip
+// when the request lacks the configured key, or nothing when the route is not limited
+func rateLimitClient(req *http.Request, config *types.RateLimit) string {
+	if config == nil {
+		return ""
+	}
+	var value string
+	switch config.KeyOn {
+	case types.HashOnHeader:
+		value = req.Header.Get(config.Key)
+	case types.HashOnCookie:
+		if cookie, err := req.Cookie(config.Key); err == nil {
+			value = cookie.Value
+		}
+	case types.HashOnQuery:
+		value = req.URL.Query().Get(config.Key)
+	}
+	if value != "" {
+		return config.KeyOn + "=" + value
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return types.HashOnIP + "=" + host
+	}
+	return types.HashOnIP + "=" + req.RemoteAddr
+}
+
+// limit counts a request against its client's rate limit and reports
+// whether it is allowed, answering 429 when it is not. Counts are weighted
+// across the current and previous windows to approximate a sliding window.
+// Requests are allowed when the counts cannot be read.
+func (p *proxyWrapper) limit(w http.ResponseWriter, req *http.Request, client string, route *types.Route) bool {
+	config := route.RateLimit
+	if config == nil {
+		return true
+	}
+	window := config.Window.Or(defaultRateLimitWindow)
+	now := time.Now().UnixNano()
+	index, elapsed := now/int64(window), time.Duration(now%int64(window))
+	key := func(index int64) string {
+		return fmt.Sprintf("%s:%d:%s", route.ID, index, client)
+	}
+	current, err := p.limits.increment(key(index), 2*window)
+	if err != nil {
+		log.Printf("Not rate limiting route %s: %v", route.ID, err)
+		return true
+	}
+	previous, err := p.limits.count(key(index - 1))
+	if err != nil {
+		log.Printf("Not rate limiting route %s: %v", route.ID, err)
+		return true
+	}
+	used := float64(previous)*float64(window-elapsed)/float64(window) + float64(current)
+	remaining := config.Requests - int(math.Ceil(used))
+	if remaining < 0 {
+		remaining = 0
+	}
+	reset := strconv.Itoa(int(math.Ceil((window - elapsed).Seconds())))
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(config.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("RateLimit-Reset", reset)
+	if used <= float64(config.Requests) {
+		return true
+	}
+	rateLimited.Add(route.ID, 1)
+	header.Set("Retry-After", reset)
+	writeError(w, req, http.StatusTooManyRequests, "Rate limit exceeded")
+	return false
+}
@@ -0,0 +1,167 @@
+package reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// sharedCache stands in for the redis instance proxies share
+type sharedCache struct {
+	mux    sync.Mutex
+	values map[string][]byte
+}
+
+func (c *sharedCache) SetField(string, string, string) error    { return nil }
+func (c *sharedCache) DeleteField(string, string) error         { return nil }
+func (c *sharedCache) GetAll(string) (map[string]string, error) { return nil, nil }
+func (c *sharedCache) Subscribe(string, func()) error           { return nil }
+func (c *sharedCache) Get(key string) ([]byte, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.values[key], nil
+}
+
+func (c *sharedCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.values[key] = value
+	return nil
+}
+
+func (c *sharedCache) Delete(keys ...string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, key := range keys {
+		delete(c.values, key)
+	}
+	return nil
+}
+
+func (c *sharedCache) Keys(pattern string) ([]string, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var keys []string
+	for key := range c.values {
+		if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (c *sharedCache) Increment(key string, ttl time.Duration) (int64, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	value, _ := strconv.ParseInt(string(c.values[key]), 10, 64)
+	value++
+	c.values[key] = []byte(strconv.FormatInt(value, 10))
+	return value, nil
+}
+
+func limitedRoute(server *httptest.Server, limit *types.RateLimit) types.Route {
+	return types.Route{ID: "limited", ProxiedURL: "ocelot.com", Upstream: server.URL, RateLimit: limit}
+}
+
+func TestRateLimitRejectsExcessRequests(t *testing.T) {
+	server := upstream("limited")
+	defer server.Close()
+	route := limitedRoute(server, &types.RateLimit{Requests: 2, Window: types.Duration(time.Hour)})
+	proxy := New(nil, nil, "secret")
+
+	for i := 0; i < 2; i++ {
+		respRec := httptest.NewRecorder()
+		proxy.ServeHTTP(respRec, routedRequest(t, "GET", "http://ocelot.com/", route))
+		if respRec.Code != http.StatusOK {
+			t.Fatal("Request ", i, " was answered ", respRec.Code, " instead of ", http.StatusOK)
+		}
+		if remaining := respRec.Header().Get("RateLimit-Remaining"); remaining != strconv.Itoa(1-i) {
+			t.Fatal("Request ", i, " had ", remaining, " requests remaining instead of ", 1-i)
+		}
+	}
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, routedRequest(t, "GET", "http://ocelot.com/", route))
+	if respRec.Code != http.StatusTooManyRequests {
+		t.Fatal("Request over the limit was answered ", respRec.Code, " instead of ", http.StatusTooManyRequests)
+	}
+	if respRec.Header().Get("RateLimit-Limit") != "2" || respRec.Header().Get("Retry-After") == "" {
+		t.Fatal("Rejected request lacked rate limit headers: ", respRec.Header())
+	}
+
+	// other clients have their own limit
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.RemoteAddr = "10.0.0.2:1234"
+	if code, _ := serve(proxy, req); code != http.StatusOK {
+		t.Fatal("Request from another client was answered ", code, " instead of ", http.StatusOK)
+	}
+}
+
+func TestRateLimitKeyedOnHeader(t *testing.T) {
+	server := upstream("limited")
+	defer server.Close()
+	route := limitedRoute(server, &types.RateLimit{Requests: 1, KeyOn: types.HashOnHeader, Key: "X-Api-Key"})
+	proxy := New(nil, nil, "secret")
+
+	for _, test := range []struct {
+		key      string
+		expected int
+	}{
+		{"alpha", http.StatusOK},
+		{"beta", http.StatusOK},
+		{"alpha", http.StatusTooManyRequests},
+	} {
+		req := routedRequest(t, "GET", "http://ocelot.com/", route)
+		req.Header.Set("X-Api-Key", test.key)
+		if code, _ := serve(proxy, req); code != test.expected {
+			t.Fatal("Request with key ", test.key, " was answered ", code, " instead of ", test.expected)
+		}
+	}
+}
+
+func TestRateLimitSharedBetweenProxies(t *testing.T) {
+	server := upstream("limited")
+	defer server.Close()
+	route := limitedRoute(server, &types.RateLimit{Requests: 3, Window: types.Duration(time.Hour)})
+	shared := &sharedCache{values: make(map[string][]byte)}
+	proxies := []Proxy{New(nil, shared, "secret"), New(nil, shared, "secret")}
+
+	for i := 0; i < 3; i++ {
+		if code, _ := serve(proxies[i%2], routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusOK {
+			t.Fatal("Request ", i, " was answered ", code, " instead of ", http.StatusOK)
+		}
+	}
+	if code, _ := serve(proxies[1], routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusTooManyRequests {
+		t.Fatal("Request over the shared limit was answered ", code, " instead of ", http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitCountsAuthenticatedKeys(t *testing.T) {
+	server := keyUpstream()
+	defer server.Close()
+	route := types.Route{ID: "tools", ProxiedURL: "ocelot.com", Upstream: server.URL, Auth: &types.Auth{Type: types.AuthAPIKey},
+		RateLimit: &types.RateLimit{Requests: 1, KeyOn: types.HashOnHeader, Key: "X-API-Key"}}
+	proxy := credentialsProxy(t, types.Credential{ID: "ci", Type: types.AuthAPIKey, Routes: []string{"tools"}}, "s3cret")
+
+	for _, test := range []struct {
+		key      string
+		expected int
+	}{
+		{"ci.s3cret", http.StatusOK},
+		// made up keys are rejected rather than given their own limit
+		{"ci.wrong", http.StatusUnauthorized},
+		{"other.s3cret", http.StatusUnauthorized},
+		{"ci.s3cret", http.StatusTooManyRequests},
+	} {
+		req := routedRequest(t, "GET", "http://ocelot.com/", route)
+		req.Header.Set("X-API-Key", test.key)
+		if code, _ := serve(proxy, req); code != test.expected {
+			t.Fatal("Request with key ", test.key, " was answered ", code, " instead of ", test.expected)
+		}
+	}
+}
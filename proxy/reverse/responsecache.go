@@ -0,0 +1,612 @@
+package reverse
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/cache"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultCacheMaxBodySize = 1 << 20
+	memoryCacheSize         = 64 << 20
+	// stale responses with validators are kept this long so they can be revalidated
+	staleRetention    = 10 * time.Minute
+	redisCachePrefix  = "go-ocelot:responses:"
+	cacheStatusHeader = "X-Cache"
+)
+
+// responseStore holds encoded responses until they expire
+type responseStore interface {
+	get(key string) []byte
+	set(key string, value []byte, ttl time.Duration)
+	keys() []string
+	remove(keys ...string)
+}
+
+// memoryStore is a least recently used responseStore bounded by the size of its values
+type memoryStore struct {
+	mux     sync.Mutex
+	max     int
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryStore(max int) *memoryStore {
+	return &memoryStore{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (s *memoryStore) get(key string) []byte {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		s.removeElement(element)
+		return nil
+	}
+	s.order.MoveToFront(element)
+	return entry.value
+}
+
+func (s *memoryStore) set(key string, value []byte, ttl time.Duration) {
+	if len(value) > s.max {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if element, ok := s.entries[key]; ok {
+		s.removeElement(element)
+	}
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+	s.size += len(value)
+	for s.size > s.max {
+		s.removeElement(s.order.Back())
+	}
+}
+
+func (s *memoryStore) keys() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *memoryStore) remove(keys ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, key := range keys {
+		if element, ok := s.entries[key]; ok {
+			s.removeElement(element)
+		}
+	}
+}
+
+func (s *memoryStore) removeElement(element *list.Element) {
+	entry := s.order.Remove(element).(*memoryEntry)
+	delete(s.entries, entry.key)
+	s.size -= len(entry.value)
+}
+
+// redisStore shares responses between proxies, failures are logged and treated as misses
+type redisStore struct {
+	cache cache.Cache
+}
+
+func (s *redisStore) get(key string) []byte {
+	value, err := s.cache.Get(redisCachePrefix + key)
+	if err != nil {
+		log.Printf("Reading cached response failed: %v", err)
+	}
+	return value
+}
+
+func (s *redisStore) set(key string, value []byte, ttl time.Duration) {
+	if err := s.cache.Set(redisCachePrefix+key, value, ttl); err != nil {
+		log.Printf("Caching response failed: %v", err)
+	}
+}
+
+func (s *redisStore) keys() []string {
+	keys, err := s.cache.Keys(redisCachePrefix + "*")
+	if err != nil {
+		log.Printf("Listing cached responses failed: %v", err)
+	}
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], redisCachePrefix)
+	}
+	return keys
+}
+
+func (s *redisStore) remove(keys ...string) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = redisCachePrefix + key
+	}
+	if err := s.cache.Delete(prefixed...); err != nil {
+		log.Printf("Purging cached responses failed: %v", err)
+	}
+}
+
+// cachedResponse is a stored response. When the response varies, the entry
+// under the request's key only lists the Vary headers and each variant is
+// stored under a key extended with their values.
+type cachedResponse struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+	Vary    []string    `json:"vary,omitempty"`
+	Shared  bool        `json:"shared,omitempty"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+}
+
+func (c *cachedResponse) fresh() bool {
+	return time.Now().Before(c.Expires)
+}
+
+func (c *cachedResponse) validated() bool {
+	return c.Header.Get("ETag") != "" || c.Header.Get("Last-Modified") != ""
+}
+
+// cacheKey is the route, host and URI of a request, separated by spaces which none of them contain
+func cacheKey(routeID string, req *http.Request) string {
+	return routeID + " " + req.Host + " " + req.URL.RequestURI()
+}
+
+// variantKey extends a key with the request's values of the headers a response varies on
+func variantKey(key string, vary []string, req *http.Request) string {
+	values := make([]string, len(vary))
+	for i, name := range vary {
+		values[i] = name + "=" + strings.Join(req.Header[name], ",")
+	}
+	return key + " " + strings.Join(values, "&")
+}
+
+// cacheControl parses the directives of a Cache-Control header
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg := strings.TrimSpace(directive), ""
+			if i := strings.Index(name, "="); i >= 0 {
+				name, arg = name[:i], strings.Trim(name[i+1:], `"`)
+			}
+			if name != "" {
+				directives[strings.ToLower(name)] = arg
+			}
+		}
+	}
+	return directives
+}
+
+// varyHeaders returns the canonical names of the headers a response varies on
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// cacheableStatus lists the statuses cacheable by default
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// sharedResponse reports whether a response explicitly allows shared caches
+// to serve it to any client, even those authenticated differently
+func sharedResponse(header http.Header) bool {
+	directives := cacheControl(header)
+	_, public := directives["public"]
+	_, sMaxAge := directives["s-maxage"]
+	return public || sMaxAge
+}
+
+// authenticated reports whether a request is authenticated, either by its own
+// Authorization header or by the route, whose responses may then be personal
+func authenticated(req *http.Request, route *types.Route) bool {
+	return req.Header.Get("Authorization") != "" || route.Auth != nil || route.JWT != nil || route.ForwardAuth != nil
+}
+
+// freshness returns how long a response may be served from the cache without
+// revalidation, and false when it must not be stored at all. Responses to
+// authenticated requests are only stored when they are shared, see RFC 9111 section 3.5.
+func freshness(status int, header http.Header, config *types.ResponseCache, authenticated bool) (time.Duration, bool) {
+	directives := cacheControl(header)
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	if !cacheableStatus[status] || noStore || private || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if authenticated && !sharedResponse(header) {
+		return 0, false
+	}
+	for _, name := range varyHeaders(header) {
+		if name == "*" {
+			return 0, false
+		}
+	}
+	var ttl time.Duration
+	if _, noCache := directives["no-cache"]; noCache {
+		ttl = 0
+	} else if age, ok := directives["s-maxage"]; ok {
+		seconds, _ := strconv.Atoi(age)
+		ttl = time.Duration(seconds) * time.Second
+	} else if age, ok := directives["max-age"]; ok {
+		seconds, _ := strconv.Atoi(age)
+		ttl = time.Duration(seconds) * time.Second
+	} else if expires := header.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		ttl = at.Sub(date)
+	} else {
+		ttl = time.Duration(config.DefaultTTL)
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+		ttl -= time.Duration(age) * time.Second
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
+}
+
+// matchETag reports whether an If-None-Match header matches an entity tag, using the weak comparison
+func matchETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCache caches the responses of the routes that configure it
+type responseCache struct {
+	memory *memoryStore
+	redis  *redisStore
+}
+
+func newResponseCache(c cache.Cache) *responseCache {
+	rc := &responseCache{memory: newMemoryStore(memoryCacheSize)}
+	if c != nil {
+		rc.redis = &redisStore{cache: c}
+	}
+	return rc
+}
+
+// store returns where the route's responses are kept, falling back to memory when redis is not available
+func (rc *responseCache) store(config *types.ResponseCache) responseStore {
+	if config.Store == types.CacheStoreRedis && rc.redis != nil {
+		return rc.redis
+	}
+	return rc.memory
+}
+
+// find returns the cached response for the request, if any, and the key it is stored under
+func (rc *responseCache) find(store responseStore, key string, req *http.Request) (*cachedResponse, string) {
+	entry := decodeResponse(store.get(key))
+	if entry == nil || len(entry.Vary) == 0 {
+		return entry, key
+	}
+	key = variantKey(key, entry.Vary, req)
+	return decodeResponse(store.get(key)), key
+}
+
+func decodeResponse(value []byte) *cachedResponse {
+	if value == nil {
+		return nil
+	}
+	entry := &cachedResponse{}
+	if err := json.Unmarshal(value, entry); err != nil {
+		log.Printf("Discarding unreadable cached response: %v", err)
+		return nil
+	}
+	return entry
+}
+
+// save stores a response, keeping stale responses that can be revalidated a while longer
+func (rc *responseCache) save(store responseStore, key string, entry *cachedResponse, req *http.Request) {
+	ttl := entry.Expires.Sub(entry.Stored)
+	if entry.validated() {
+		ttl += staleRetention
+	}
+	if ttl <= 0 {
+		return
+	}
+	if vary := varyHeaders(entry.Header); len(vary) > 0 {
+		marker, _ := json.Marshal(&cachedResponse{Vary: vary, Stored: entry.Stored, Expires: entry.Expires})
+		store.set(key, marker, ttl)
+		key = variantKey(key, vary, req)
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Caching response failed: %v", err)
+		return
+	}
+	store.set(key, value, ttl)
+}
+
+// lookup answers the request from the cache when it holds a fresh response.
+// Otherwise it returns the writer the upstream response must go through to
+// be cached, and a function to call once that response has been written.
+// Stale responses are revalidated with the upstream using their validators.
+func (rc *responseCache) lookup(w http.ResponseWriter, req *http.Request, route *types.Route) (http.ResponseWriter, *http.Request, func(), bool) {
+	config := route.Cache
+	if config == nil || (req.Method != "GET" && req.Method != "HEAD") || isWebSocket(req) {
+		return w, req, func() {}, false
+	}
+	directives := cacheControl(req.Header)
+	if _, noStore := directives["no-store"]; noStore {
+		return w, req, func() {}, false
+	}
+	store := rc.store(config)
+	key := cacheKey(route.ID, req)
+	entry, entryKey := rc.find(store, key, req)
+	personal := authenticated(req, route)
+	if entry != nil && personal && !entry.Shared {
+		entry = nil
+	}
+	_, noCache := directives["no-cache"]
+	if entry != nil && entry.fresh() && !noCache {
+		cacheHits.Add(route.ID, 1)
+		serveCached(w, req, entry, "HIT")
+		return w, req, func() {}, true
+	}
+	cacheMisses.Add(route.ID, 1)
+	if req.Method == "HEAD" {
+		return w, req, func() {}, false
+	}
+
+	max := config.MaxBodySize
+	if max <= 0 {
+		max = defaultCacheMaxBodySize
+	}
+	cw := &cacheWriter{ResponseWriter: w, max: max}
+	var validator string
+	if entry != nil && entry.validated() && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		cw.stale = entry
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			validator = "If-None-Match"
+			req.Header.Set(validator, etag)
+		} else {
+			validator = "If-Modified-Since"
+			req.Header.Set(validator, entry.Header.Get("Last-Modified"))
+		}
+	}
+	return cw, req.WithContext(newCacheContext(req.Context(), cw)), func() {
+		if err := recover(); err != nil {
+			// the response was cut short, so nothing is cached
+			if validator != "" {
+				req.Header.Del(validator)
+			}
+			panic(err)
+		}
+		if validator != "" {
+			req.Header.Del(validator)
+		}
+		now := time.Now()
+		if cw.notModified {
+			for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+				if value := cw.header.Get(name); value != "" {
+					entry.Header.Set(name, value)
+				}
+			}
+			if ttl, ok := freshness(entry.Status, entry.Header, config, personal); ok {
+				entry.Shared = sharedResponse(entry.Header)
+				entry.Stored, entry.Expires = now, now.Add(ttl)
+				rc.save(store, entryKey, entry, req)
+			}
+			cacheRevalidations.Add(route.ID, 1)
+			serveCached(cw.ResponseWriter, req, entry, "REVALIDATED")
+			return
+		}
+		if cw.overflow || !cw.complete {
+			return
+		}
+		if ttl, ok := freshness(cw.status, cw.header, config, personal); ok {
+			rc.save(store, key, &cachedResponse{
+				Status:  cw.status,
+				Header:  cw.header,
+				Body:    cw.body.Bytes(),
+				Shared:  sharedResponse(cw.header),
+				Stored:  now,
+				Expires: now.Add(ttl),
+			}, req)
+		}
+	}, false
+}
+
+// serveCached writes a cached response, or not modified when it matches the request's validator
+func serveCached(w http.ResponseWriter, req *http.Request, entry *cachedResponse, status string) {
+	header := w.Header()
+	header.Del("Content-Length")
+	// headers set by the proxy and middleware for this request take precedence
+	for name, values := range entry.Header {
+		if _, set := header[name]; !set {
+			header[name] = values
+		}
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	header.Set(cacheStatusHeader, status)
+	if etag := entry.Header.Get("ETag"); etag != "" && matchETag(req.Header.Get("If-None-Match"), etag) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(entry.Status)
+	if req.Method != "HEAD" {
+		w.Write(entry.Body)
+	}
+}
+
+// purge removes the cached responses matching a route ID, host and URI
+// prefix, an empty value matches anything, and returns how many were removed.
+// Responses shared in redis are purged for every proxy, those kept in memory
+// only from this proxy's store.
+func (rc *responseCache) purge(routeID, host, prefix string) int {
+	stores := []responseStore{rc.memory}
+	if rc.redis != nil {
+		stores = append(stores, rc.redis)
+	}
+	purged := 0
+	for _, store := range stores {
+		var matched []string
+		for _, key := range store.keys() {
+			parts := strings.SplitN(key, " ", 4)
+			if len(parts) < 3 {
+				continue
+			}
+			if (routeID == "" || parts[0] == routeID) && (host == "" || parts[1] == host) && strings.HasPrefix(parts[2], prefix) {
+				matched = append(matched, key)
+			}
+		}
+		store.remove(matched...)
+		purged += len(matched)
+	}
+	return purged
+}
+
+// cacheWriter keeps a copy of the response written through it to be cached.
+// When revalidating a stale response, a not modified answer from the
+// upstream is held back so the cached response can be served instead.
+type cacheWriter struct {
+	http.ResponseWriter
+	max         int64
+	stale       *cachedResponse
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	overflow    bool
+	notModified bool
+	complete    bool
+}
+
+type cacheWriterKey int
+
+const cacheWriterContextKey cacheWriterKey = 0
+
+func newCacheContext(ctx context.Context, w *cacheWriter) context.Context {
+	return context.WithValue(ctx, cacheWriterContextKey, w)
+}
+
+func cacheWriterFromContext(ctx context.Context) *cacheWriter {
+	w, _ := ctx.Value(cacheWriterContextKey).(*cacheWriter)
+	return w
+}
+
+// capture keeps the upstream response's own headers, without those the proxy
+// and middleware set, and watches its body so only complete responses are cached
+func (w *cacheWriter) capture(resp *http.Response) {
+	w.header = resp.Header.Clone()
+	resp.Body = &cacheBody{ReadCloser: resp.Body, writer: w, length: resp.ContentLength}
+}
+
+// cacheBody marks its writer complete once the upstream body is read to the end
+type cacheBody struct {
+	io.ReadCloser
+	writer *cacheWriter
+	length int64
+	read   int64
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err == io.EOF {
+		b.writer.complete = b.length < 0 || b.read == b.length
+	}
+	return n, err
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if code < http.StatusOK {
+		// informational responses precede the one to cache
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if w.stale != nil && code == http.StatusNotModified {
+		w.notModified = true
+		return
+	}
+	w.Header().Set(cacheStatusHeader, "MISS")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.max {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streamed responses through
+func (w *cacheWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.notModified {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
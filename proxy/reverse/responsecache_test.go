@@ -0,0 +1,269 @@
+package reverse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// cachedUpstream counts its requests and answers them with the headers set by respond
+func cachedUpstream(requests *int64, respond func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		respond(w, r)
+	}))
+}
+
+func serveCachedRequest(proxy Proxy, req *http.Request) *httptest.ResponseRecorder {
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, req)
+	return respRec
+}
+
+func TestCacheServesFreshResponses(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("cached"))
+	})
+	defer server.Close()
+	route := types.Route{ID: "cached", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{}}
+	proxy := New(nil, nil, "secret")
+
+	first := serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/page", route))
+	second := serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/page", route))
+
+	if first.Header().Get(cacheStatusHeader) != "MISS" || second.Header().Get(cacheStatusHeader) != "HIT" {
+		t.Fatal("Cache answered ", first.Header().Get(cacheStatusHeader), " then ", second.Header().Get(cacheStatusHeader), " instead of MISS then HIT")
+	}
+	if body := second.Body.String(); body != "cached" {
+		t.Fatal("Cache answered ", body, " instead of cached")
+	}
+	if requests != 1 {
+		t.Fatal("Upstream received ", requests, " requests instead of 1")
+	}
+}
+
+func TestCacheSkipsTruncatedResponses(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		buf.WriteString("HTTP/1.1 200 OK\r\nCache-Control: max-age=60\r\nContent-Length: 100\r\n\r\nhello world!")
+		buf.Flush()
+		conn.Close()
+	})
+	defer server.Close()
+	route := types.Route{ID: "truncated", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{}}
+	proxy := New(nil, nil, "secret")
+
+	for i := 0; i < 2; i++ {
+		req := routedRequest(t, "GET", "http://ocelot.com/page", route)
+		req = req.WithContext(context.WithValue(req.Context(), http.ServerContextKey, &http.Server{}))
+		func() {
+			defer func() { recover() }()
+			serveCachedRequest(proxy, req)
+		}()
+	}
+	if requests != 2 {
+		t.Fatal("Upstream received ", requests, " requests instead of 2")
+	}
+}
+
+func TestCacheKeepsProxyHeaders(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("cached"))
+	})
+	defer server.Close()
+	route := types.Route{ID: "limited", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{},
+		RateLimit: &types.RateLimit{Requests: 10, Window: types.Duration(time.Hour)}}
+	proxy := New(nil, nil, "secret")
+
+	serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/page", route))
+	respRec := serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/page", route))
+
+	if status := respRec.Header().Get(cacheStatusHeader); status != "HIT" {
+		t.Fatal("Cache answered ", status, " instead of HIT")
+	}
+	if remaining := respRec.Header().Get("RateLimit-Remaining"); remaining != "8" {
+		t.Fatal("Cached response had ", remaining, " requests remaining instead of 8")
+	}
+}
+
+func TestCacheSkipsUncacheableResponses(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Write([]byte("fresh"))
+	})
+	defer server.Close()
+	route := types.Route{ID: "uncached", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{DefaultTTL: types.Duration(time.Minute)}}
+	proxy := New(nil, nil, "secret")
+
+	for _, cc := range []string{"no-store", "private"} {
+		serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/?cc="+cc, route))
+		serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/?cc="+cc, route))
+	}
+	if requests != 4 {
+		t.Fatal("Upstream received ", requests, " requests instead of 4")
+	}
+}
+
+func TestCacheStoresVariants(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+	defer server.Close()
+	route := types.Route{ID: "varied", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{}}
+	proxy := New(nil, nil, "secret")
+
+	for _, language := range []string{"en", "fr", "en", "fr"} {
+		req := routedRequest(t, "GET", "http://ocelot.com/", route)
+		req.Header.Set("Accept-Language", language)
+		if body := serveCachedRequest(proxy, req).Body.String(); body != language {
+			t.Fatal("Cache answered ", body, " instead of ", language)
+		}
+	}
+	if requests != 2 {
+		t.Fatal("Upstream received ", requests, " requests instead of 2")
+	}
+}
+
+func TestCacheRevalidatesStaleResponses(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("document"))
+	})
+	defer server.Close()
+	route := types.Route{ID: "revalidated", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{}}
+	proxy := New(nil, nil, "secret")
+
+	serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/", route))
+	second := serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/", route))
+
+	if second.Code != http.StatusOK || second.Body.String() != "document" || second.Header().Get(cacheStatusHeader) != "REVALIDATED" {
+		t.Fatal("Cache answered ", second.Code, " with ", second.Body.String(), " instead of the revalidated document")
+	}
+	if requests != 2 {
+		t.Fatal("Upstream received ", requests, " requests instead of 2")
+	}
+
+	// clients holding the current version are told it is unchanged
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.Header.Set("If-None-Match", `"v1"`)
+	if code := serveCachedRequest(proxy, req).Code; code != http.StatusNotModified {
+		t.Fatal("Cache answered ", code, " instead of ", http.StatusNotModified)
+	}
+}
+
+func TestCacheKeepsAuthenticatedResponsesPersonal(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	defer server.Close()
+	route := types.Route{ID: "personal", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{}}
+	proxy := New(nil, nil, "secret")
+
+	for _, token := range []string{"Bearer larry", "Bearer moe", "Bearer larry"} {
+		req := routedRequest(t, "GET", "http://ocelot.com/?cc=max-age=60", route)
+		req.Header.Set("Authorization", token)
+		if resp := serveCachedRequest(proxy, req); resp.Body.String() != token || resp.Header().Get(cacheStatusHeader) == "HIT" {
+			t.Fatal("Request with ", token, " was answered ", resp.Body.String(), " from ", resp.Header().Get(cacheStatusHeader), " instead of its own response")
+		}
+	}
+	if requests != 3 {
+		t.Fatal("Upstream received ", requests, " requests instead of 3")
+	}
+
+	// public responses are shared by every client
+	for i, token := range []string{"Bearer larry", "Bearer moe"} {
+		req := routedRequest(t, "GET", "http://ocelot.com/?cc=public,max-age=60", route)
+		req.Header.Set("Authorization", token)
+		if resp := serveCachedRequest(proxy, req); i == 1 && (resp.Body.String() != "Bearer larry" || resp.Header().Get(cacheStatusHeader) != "HIT") {
+			t.Fatal("Public response was answered ", resp.Body.String(), " from ", resp.Header().Get(cacheStatusHeader), " instead of the cached response")
+		}
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.URL.Path))
+	})
+	defer server.Close()
+	route := types.Route{ID: "purged", ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{}}
+	proxy := New(nil, nil, "secret")
+	for _, path := range []string{"/static/a.css", "/static/b.css", "/index.html"} {
+		serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com"+path, route))
+	}
+
+	if purged := proxy.Purge("other", "", ""); purged != 0 {
+		t.Fatal("Purged ", purged, " responses of another route instead of 0")
+	}
+	if purged := proxy.Purge("purged", "ocelot.com", "/static/"); purged != 2 {
+		t.Fatal("Purged ", purged, " responses instead of 2")
+	}
+	if status := serveCachedRequest(proxy, routedRequest(t, "GET", "http://ocelot.com/index.html", route)).Header().Get(cacheStatusHeader); status != "HIT" {
+		t.Fatal("Cache answered ", status, " for a response that was not purged instead of HIT")
+	}
+}
+
+func TestCachePurgeReach(t *testing.T) {
+	var requests int64
+	server := cachedUpstream(&requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.URL.Path))
+	})
+	defer server.Close()
+	shared := &sharedCache{values: make(map[string][]byte)}
+	first, second := New(nil, shared, "secret"), New(nil, shared, "secret")
+	for _, store := range []string{types.CacheStoreMemory, types.CacheStoreRedis} {
+		route := types.Route{ID: store, ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{Store: store}}
+		serveCachedRequest(first, routedRequest(t, "GET", "http://ocelot.com/page", route))
+		serveCachedRequest(second, routedRequest(t, "GET", "http://ocelot.com/page", route))
+	}
+
+	// responses in redis are shared, so the second proxy only kept the one in memory
+	if purged := first.Purge("", "", ""); purged != 2 {
+		t.Fatal("Purged ", purged, " responses instead of 2")
+	}
+	for store, expected := range map[string]string{types.CacheStoreMemory: "HIT", types.CacheStoreRedis: "MISS"} {
+		route := types.Route{ID: store, ProxiedURL: "ocelot.com", Upstream: server.URL, Cache: &types.ResponseCache{Store: store}}
+		if status := serveCachedRequest(second, routedRequest(t, "GET", "http://ocelot.com/page", route)).Header().Get(cacheStatusHeader); status != expected {
+			t.Fatal("Other proxy answered ", status, " from its ", store, " store instead of ", expected)
+		}
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := newMemoryStore(10)
+	store.set("a", []byte("aaaa"), time.Minute)
+	store.set("b", []byte("bbbb"), time.Minute)
+	store.get("a")
+	store.set("c", []byte("cccc"), time.Minute)
+
+	if store.get("b") != nil {
+		t.Fatal("Least recently used entry was not evicted")
+	}
+	if store.get("a") == nil || store.get("c") == nil {
+		t.Fatal("Recently used entries were evicted")
+	}
+}
@@ -0,0 +1,249 @@
+package reverse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+const (
+	defaultRetryAttempts   = 2
+	defaultRetryBackoff    = 25 * time.Millisecond
+	defaultRetryMaxBackoff = 250 * time.Millisecond
+	defaultRetryBudget     = 20
+	retryBudgetWindow      = 10 * time.Second
+	// routes with little traffic may always retry this many times per window
+	minRetriesPerWindow = 3
+	// larger bodies are streamed to the upstream and never retried
+	maxRetryBodySize = 64 << 10
+)
+
+var idempotentMethods = map[string]bool{
+	"GET": true, "HEAD": true, "OPTIONS": true, "TRACE": true, "PUT": true, "DELETE": true,
+}
+
+// errRetryStatus is returned from ModifyResponse to discard a response that will be retried
+var errRetryStatus = errors.New("Retrying upstream response")
+
+// retrier applies a route's retry policy and keeps its retry budget
+type retrier struct {
+	policy  *types.RetryPolicy
+	routeID string
+
+	mux               sync.Mutex
+	windowStart       time.Time
+	requests, retries int
+}
+
+// newRetrier returns nil when the route has no retry policy
+func newRetrier(routeID string, policy *types.RetryPolicy) *retrier {
+	if policy == nil {
+		return nil
+	}
+	return &retrier{policy: policy, routeID: routeID}
+}
+
+// inherit takes over the retry budget of a previous backend of the route,
+// unless either has no retry policy or the policy changed
+func (r *retrier) inherit(old *retrier) {
+	if r == nil || old == nil || !reflect.DeepEqual(r.policy, old.policy) {
+		return
+	}
+	old.mux.Lock()
+	windowStart, requests, retries := old.windowStart, old.requests, old.retries
+	old.mux.Unlock()
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.windowStart, r.requests, r.retries = windowStart, requests, retries
+}
+
+func (r *retrier) retryOn(condition string) bool {
+	if len(r.policy.RetryOn) == 0 {
+		return condition == types.RetryConnectFailure
+	}
+	for _, c := range r.policy.RetryOn {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports whether a failed attempt may be retried under the policy,
+// err is set when no response was received
+func (r *retrier) retryable(status int, err error, idempotent bool) bool {
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return r.retryOn(types.RetryConnectFailure)
+		}
+		var netErr net.Error
+		return idempotent && errors.As(err, &netErr) && netErr.Timeout() && r.retryOn(types.RetryTimeout)
+	}
+	if !idempotent {
+		return false
+	}
+	if status >= http.StatusInternalServerError && r.retryOn(types.Retry5xx) {
+		return true
+	}
+	for _, code := range r.policy.StatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// window resets the budget counters once a window has passed, it is called with the lock held
+func (r *retrier) window(now time.Time) {
+	if now.Sub(r.windowStart) > retryBudgetWindow {
+		r.windowStart, r.requests, r.retries = now, 0, 0
+	}
+}
+
+// spend counts a retry against the budget, reporting whether it was within it
+func (r *retrier) spend() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.window(time.Now())
+	budget := r.policy.Budget
+	if budget == 0 {
+		budget = defaultRetryBudget
+	}
+	if r.retries >= minRetriesPerWindow && float64(r.retries) >= budget/100*float64(r.requests) {
+		return false
+	}
+	r.retries++
+	return true
+}
+
+// begin counts a request towards the budget and prepares it for retries, it
+// returns nil when the request cannot be retried
+func (r *retrier) begin(req *http.Request) *attempt {
+	if r == nil {
+		return nil
+	}
+	r.mux.Lock()
+	r.window(time.Now())
+	r.requests++
+	r.mux.Unlock()
+
+	attempts := r.policy.Attempts
+	if attempts == 0 {
+		attempts = defaultRetryAttempts
+	}
+	a := &attempt{retrier: r, idempotent: idempotentMethods[req.Method], remaining: attempts - 1}
+	if a.remaining <= 0 {
+		return nil
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength <= 0 || req.ContentLength > maxRetryBodySize {
+			return nil
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+		if err != nil {
+			// forward what was read, the upstream sees the same error for the rest
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			return nil
+		}
+		a.body = body
+	}
+	return a
+}
+
+// attempt follows a request through its retries, the response hooks find it in the request context
+type attempt struct {
+	retrier    *retrier
+	idempotent bool
+	body       []byte
+	remaining  int
+	tries      int
+	// retry is set by the hooks when the last try failed and will be retried
+	retry bool
+}
+
+// next prepares the request for another try
+func (a *attempt) next(req *http.Request) {
+	a.retry = false
+	a.tries++
+	if a.body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(a.body))
+	}
+}
+
+// fail decides whether the failed try will be retried, marking it if so
+func (a *attempt) fail(status int, err error) bool {
+	if a == nil || a.remaining <= 0 || !a.retrier.retryable(status, err, a.idempotent) {
+		return false
+	}
+	if !a.retrier.spend() {
+		log.Printf("Retry budget exhausted for route %s", a.retrier.routeID)
+		retryBudgetExhausted.Add(a.retrier.routeID, 1)
+		return false
+	}
+	a.remaining--
+	a.retry = true
+	return true
+}
+
+// wait sleeps a jittered exponential backoff before the next try, it returns
+// false if the client went away in the meantime
+func (a *attempt) wait(ctx context.Context) bool {
+	backoff := a.retrier.policy.Backoff.Or(defaultRetryBackoff) << uint(a.tries-1)
+	if max := a.retrier.policy.MaxBackoff.Or(defaultRetryMaxBackoff); backoff > max || backoff <= 0 {
+		backoff = max
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+type attemptKey int
+
+const attemptContextKey attemptKey = 0
+
+func newAttemptContext(ctx context.Context, a *attempt) context.Context {
+	return context.WithValue(ctx, attemptContextKey, a)
+}
+
+func attemptFromContext(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptContextKey).(*attempt)
+	return a
+}
+
+// nextTarget picks a target for a try, avoiding targets that were already tried when possible
+func (b *backend) nextTarget(req *http.Request, tried []*Target) *Target {
+	target := b.balancer.Next(req)
+	for i := 0; i < len(b.targets) && target != nil && contains(tried, target); i++ {
+		target = b.balancer.Next(req)
+	}
+	return target
+}
+
+func contains(targets []*Target, target *Target) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
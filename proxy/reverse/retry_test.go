@@ -0,0 +1,117 @@
+package reverse
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func TestRetryOnAnotherTarget(t *testing.T) {
+	closed := upstream("closed")
+	closed.Close()
+	well := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer well.Close()
+
+	route := types.Route{
+		ID:         "retried",
+		ProxiedURL: "ocelot.com",
+		Targets:    []types.Target{{URL: closed.URL}, {URL: well.URL}},
+		Retry:      &types.RetryPolicy{Backoff: types.Duration(time.Millisecond), Budget: 100},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	for i := 0; i < 4; i++ {
+		req := routedRequest(t, "POST", "http://ocelot.com/", route)
+		req.Body, req.ContentLength = ioutil.NopCloser(strings.NewReader("order")), 5
+		if code, body := serve(proxy, req); code != http.StatusOK || body != "order" {
+			t.Fatal("Proxy answered ", code, " with ", body, " instead of 200 with the replayed body")
+		}
+	}
+}
+
+func TestRetryStatusOnlyForIdempotentMethods(t *testing.T) {
+	var requests int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	route := types.Route{
+		ID:         "idempotent",
+		ProxiedURL: "ocelot.com",
+		Upstream:   failing.URL,
+		Retry:      &types.RetryPolicy{Attempts: 3, RetryOn: []string{types.Retry5xx}, Backoff: types.Duration(time.Millisecond)},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	if code, _ := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != http.StatusServiceUnavailable {
+		t.Fatal("Proxy returned ", code, " instead of ", http.StatusServiceUnavailable)
+	}
+	if count := atomic.LoadInt32(&requests); count != 3 {
+		t.Fatal("GET was tried ", count, " times instead of 3")
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	serve(proxy, routedRequest(t, "POST", "http://ocelot.com/", route))
+	if count := atomic.LoadInt32(&requests); count != 1 {
+		t.Fatal("POST was tried ", count, " times instead of once")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	r := newRetrier("budgeted", &types.RetryPolicy{Budget: 10})
+	for i := 0; i < 50; i++ {
+		r.begin(httptest.NewRequest("GET", "/", nil))
+	}
+	spent := 0
+	for r.spend() {
+		spent++
+	}
+	if spent != 5 {
+		t.Fatal("Budget allowed ", spent, " retries instead of 5")
+	}
+}
+
+func TestRetryUnbufferedBody(t *testing.T) {
+	r := newRetrier("streamed", &types.RetryPolicy{})
+	req := httptest.NewRequest("PUT", "/", strings.NewReader("streamed"))
+	req.ContentLength = -1
+	if r.begin(req) != nil {
+		t.Fatal("Request with a body of unknown length was prepared for retries")
+	}
+}
+
+// failingReader returns its data, then fails like a client connection dropping mid-body
+type failingReader struct {
+	data string
+	read bool
+}
+
+func (r *failingReader) Read(b []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection reset")
+	}
+	r.read = true
+	return copy(b, r.data), nil
+}
+
+func TestRetryKeepsPartiallyReadBody(t *testing.T) {
+	r := newRetrier("partial", &types.RetryPolicy{})
+	req := httptest.NewRequest("PUT", "/", &failingReader{data: "partial"})
+	req.ContentLength = 100
+	if r.begin(req) != nil {
+		t.Fatal("Request with a failing body was prepared for retries")
+	}
+	if body, err := ioutil.ReadAll(req.Body); string(body) != "partial" || err == nil {
+		t.Fatal("Forwarded body was ", string(body), " with error ", err, " instead of the partial body and its error")
+	}
+}
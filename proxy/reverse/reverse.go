@@ -1,12 +1,19 @@
 package reverse
 
 import (
-	"fmt"
+	"context"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ocelotconsulting/go-ocelot/cache"
 	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
 )
 
 func singleJoiningSlash(a, b string) string {
@@ -21,27 +28,262 @@ func singleJoiningSlash(a, b string) string {
 	return a + b
 }
 
-// New returns a new ReverseProxy that routes
-// URLs to the route information contained in the cache.
-// NewSingleHostReverseProxy does not rewrite the Host header.
-// To rewrite Host headers, use ReverseProxy directly with a custom
-// Director policy.
-func New(r routes.Repository) *httputil.ReverseProxy {
-	director := func(req *http.Request) {
-		req.URL.Scheme = "http" // terminate ssl here
-
-		pathToMatch := ""
-		if req.URL.Path != "/" {
-			pathToMatch = req.URL.Path
-		}
-		if route := routes.ResolveRoute(pathToMatch, req.Host, r.Routes()); route != nil {
-			req.URL.Host = fmt.Sprintf("%s:%d", route.ID, route.TargetPort)
-			req.URL.Path = singleJoiningSlash("", req.URL.Path)
-			if _, ok := req.Header["User-Agent"]; !ok {
-				// explicitly disable User-Agent so it's not set to default value
-				req.Header.Set("User-Agent", "")
+// explicitUpstream reports whether a route is forwarded to upstreams it lists,
+// rather than to a Docker service
+func explicitUpstream(route *types.Route) bool {
+	return route.Upstream != "" || len(route.Targets) > 0 || route.Split != nil
+}
+
+func director(req *http.Request) {
+	req.URL.Scheme = "http" // terminate ssl here
+
+	// the route and target were chosen by the proxy before reaching here
+	match, target := routes.FromContext(req.Context()), targetFromContext(req.Context())
+	if match == nil || target == nil {
+		return
+	}
+	route := match.Route
+	req.URL.Scheme = target.URL.Scheme
+	req.URL.Host = target.URL.Host
+	// the forwarded prefix only ever comes from the proxy
+	req.Header.Del(routes.ForwardedPrefixHeader)
+	if path, stripped := match.RewritePath(req.URL.Path); path != req.URL.Path {
+		req.URL.Path, req.URL.RawPath = path, ""
+		if stripped != "" {
+			req.Header.Set(routes.ForwardedPrefixHeader, stripped)
+		}
+	}
+	if target.URL.Path != "" {
+		req.URL.RawPath = ""
+	}
+	req.URL.Path = singleJoiningSlash(target.URL.Path, req.URL.Path)
+	if target.URL.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = target.URL.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = target.URL.RawQuery + "&" + req.URL.RawQuery
+	}
+	if explicitUpstream(route) && !route.PreserveHost {
+		// explicit upstreams may be virtual hosts, or checked against the TLS server name
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.Host = target.URL.Host
+	}
+	// the subdomain header only ever comes from the proxy
+	req.Header.Del(routes.DefaultSubdomainHeader)
+	if route.SubdomainHeader != "" {
+		req.Header.Del(route.SubdomainHeader)
+	}
+	if header := match.SubdomainHeader(); header != "" {
+		req.Header.Set(header, match.Subdomain)
+	}
+	for header, value := range route.Headers {
+		req.Header.Set(header, match.Expand(value))
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// modifyResponse records upstream responses for outlier detection and the
+// circuit breaker, discarding those that will be retried and translating
+// errors for gRPC clients
+func modifyResponse(resp *http.Response) error {
+	ctx := resp.Request.Context()
+	if target := targetFromContext(ctx); target != nil {
+		target.recordResult(resp.StatusCode, false)
+		target.breaker.record(resp.StatusCode >= http.StatusInternalServerError)
+	}
+	if attemptFromContext(ctx).fail(resp.StatusCode, nil) {
+		return errRetryStatus
+	}
+	grpcResponse(resp)
+	if cw := cacheWriterFromContext(ctx); cw != nil {
+		cw.capture(resp)
+	}
+	return nil
+}
+
+// errorHandler records failed upstream requests before answering with a bad
+// gateway like httputil.ReverseProxy does by default, or a gateway timeout,
+// unless they will be retried
+func errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	if err == errRetryStatus {
+		return
+	}
+	ctx := req.Context()
+	target := targetFromContext(ctx)
+	if target != nil {
+		// requests abandoned by the client say nothing about the upstream
+		if ctx.Err() == context.Canceled {
+			target.breaker.abandon()
+		} else {
+			target.recordResult(0, true)
+			target.breaker.record(true)
+		}
+	}
+	if ctx.Err() == nil && attemptFromContext(ctx).fail(0, err) {
+		return
+	}
+	if reason := timeoutReason(ctx, err); reason != "" && target != nil {
+		gatewayTimeout(w, req, target.routeID, reason, err)
+		return
+	}
+	log.Printf("http: proxy error: %v", err)
+	writeError(w, req, http.StatusBadGateway, "")
+}
+
+// newReverseProxy returns the reverse proxy forwarding requests to the targets of a route
+func newReverseProxy(route *types.Route, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := &httputil.ReverseProxy{
+		Director:       director,
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   errorHandler,
+		FlushInterval:  time.Duration(route.FlushInterval),
+	}
+	if transport != nil {
+		proxy.Transport = transport
+	}
+	return proxy
+}
+
+// Proxy forwards requests to the upstream targets of the route they were
+// resolved to, keeping the state of each route's targets between requests
+type Proxy interface {
+	http.Handler
+	Start()
+	Upstreams() map[string][]types.TargetStatus
+	Breakers() map[string]types.BreakerStatus
+	Purge(routeID, host, prefix string) int
+}
+
+type proxyWrapper struct {
+	repo     routes.Repository
+	backends map[string]*backend
+	upgrades map[string]*int64
+	secret   []byte
+	cache    *responseCache
+	limits   counterStore
+	verified *verifiedSecrets
+	mux      sync.RWMutex
+}
+
+// ServeHTTP balances the request over the targets of its route, the route
+// must have been stored in the request context with routes.NewContext
+func (p *proxyWrapper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	match := routes.FromContext(req.Context())
+	if match == nil {
+		http.NotFound(w, req)
+		return
+	}
+	b := p.backend(match.Route)
+	// clients are identified before authentication removes credentials, but
+	// only counted once authenticated so they cannot make up keys
+	client := rateLimitClient(req, match.Route.RateLimit)
+	if !p.authorize(w, req, match.Route) || !b.auth.authenticate(w, req, match.Route.ID) ||
+		!b.forward.authorize(w, req, match.Route.ID) || !p.limit(w, req, client, match.Route) {
+		return
+	}
+	if b.err != nil {
+		writeError(w, req, http.StatusBadGateway, "")
+		return
+	}
+	w, req, cached, served := p.cache.lookup(w, req, match.Route)
+	if served {
+		return
+	}
+	defer cached()
+	timeouts := match.Route.Timeouts
+	// rejected connections must not take a half-open breaker trial
+	if isWebSocket(req) {
+		if !p.openWebSocket(b) {
+			webSocketRejections.Add(match.Route.ID, 1)
+			http.Error(w, "Too many WebSocket connections", http.StatusServiceUnavailable)
+			return
+		}
+		defer p.closeWebSocket(b)
+		w = &upgradeWriter{ResponseWriter: w, routeID: match.Route.ID, timeout: webSocketIdleTimeout(match.Route)}
+		// upgraded connections are only bounded by their idle timeout
+		timeouts = nil
+	}
+	if ok, retryAfter := b.breaker.allow(); !ok {
+		breakerRejections.Add(match.Route.ID, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, req, http.StatusServiceUnavailable, "Circuit breaker open")
+		return
+	}
+	if b.split != nil {
+		b.split.assign(w, req)
+	}
+	pinned := p.pinned(b, req)
+	w, mirrored := b.mirror.shadow(w, req)
+	defer mirrored()
+	ctx, cancel := withDeadline(req.Context(), timeouts)
+	defer cancel()
+	req = req.WithContext(ctx)
+	try := b.retrier.begin(req)
+	var tried []*Target
+	for {
+		target := pinned
+		if target == nil || len(tried) > 0 {
+			target = b.nextTarget(req, tried)
+		}
+		if target == nil {
+			b.breaker.record(true)
+			log.Printf("No upstream available for route %s", match.Route.ID)
+			writeError(w, req, http.StatusServiceUnavailable, "No upstream available")
+			return
+		}
+		tried = append(tried, target)
+		if b.route.StickySession != nil && target != pinned {
+			p.pin(w, b, target)
+		}
+		attemptCtx := newTargetContext(ctx, target)
+		if try != nil {
+			try.next(req)
+			attemptCtx = newAttemptContext(attemptCtx, try)
+		}
+		// released even when the client aborts and the reverse proxy panics
+		func() {
+			target.acquire()
+			defer target.release()
+			b.proxy.ServeHTTP(w, req.WithContext(attemptCtx))
+		}()
+		if try == nil || !try.retry {
+			return
+		}
+		log.Printf("Retrying request to route %s after failure from %s", match.Route.ID, target.URL)
+		retries.Add(match.Route.ID, 1)
+		if !try.wait(ctx) {
+			if reason := timeoutReason(ctx, nil); reason != "" {
+				gatewayTimeout(w, req, match.Route.ID, reason, ctx.Err())
+			} else {
+				writeError(w, req, http.StatusBadGateway, "")
 			}
+			return
 		}
 	}
-	return &httputil.ReverseProxy{Director: director}
+}
+
+// Purge removes cached responses by route ID, host and URI prefix, empty values match any.
+// Responses kept in memory are only removed from this proxy.
+func (p *proxyWrapper) Purge(routeID, host, prefix string) int {
+	return p.cache.purge(routeID, host, prefix)
+}
+
+// New returns a new Proxy that routes requests to the upstreams of the route
+// information contained in the cache. The Host header is only rewritten for
+// routes with explicit upstreams that do not preserve it.
+// Rate limits are counted and responses of routes caching in redis are kept
+// in c, which may be nil to keep both in memory. Affinity cookies for sticky
+// sessions are signed with stickySecret.
+func New(r routes.Repository, c cache.Cache, stickySecret string) Proxy {
+	return Proxy(&proxyWrapper{
+		repo:     r,
+		backends: make(map[string]*backend),
+		upgrades: make(map[string]*int64),
+		secret:   newSecret(stickySecret),
+		cache:    newResponseCache(c),
+		limits:   newCounterStore(c),
+		verified: newVerifiedSecrets(),
+	})
 }
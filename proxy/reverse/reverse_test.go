@@ -0,0 +1,176 @@
+package reverse
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// routedRequest resolves a request against routes and stores the match in its context like the proxy handler does
+func routedRequest(t *testing.T, method, url string, routeList ...types.Route) *http.Request {
+	routeMap := make(map[string]types.Route)
+	for _, route := range routeList {
+		routeMap[route.ID] = route
+	}
+	req := httptest.NewRequest(method, url, nil)
+	match := routes.ResolveRoute(req, routes.NewIndex(routeMap, 0))
+	if match == nil {
+		t.Fatal("No route resolved for ", url)
+	}
+	return req.WithContext(routes.NewContext(req.Context(), match))
+}
+
+// directedRequest runs the director for a request routed to the first target of its route
+func directedRequest(t *testing.T, url string, route types.Route) *http.Request {
+	req := routedRequest(t, "GET", url, route)
+	target := newBackend(route).targets[0]
+	req = req.WithContext(newTargetContext(req.Context(), target))
+	director(req)
+	return req
+}
+
+func TestDirectorRewritesPath(t *testing.T) {
+	req := directedRequest(t, "http://ocelot.com/billing/invoices", types.Route{
+		ID:         "billing",
+		TargetPort: 8080,
+		ProxiedURL: "{tenant}.com/billing",
+		Rewrite:    &types.Rewrite{StripPrefix: true},
+		Headers:    map[string]string{"X-Tenant": "{tenant}"},
+	})
+
+	if req.URL.Host != "billing:8080" {
+		t.Fatal("Director set host ", req.URL.Host, " instead of billing:8080")
+	}
+	if req.URL.Path != "/invoices" {
+		t.Fatal("Director set path ", req.URL.Path, " instead of /invoices")
+	}
+	if prefix := req.Header.Get(routes.ForwardedPrefixHeader); prefix != "/billing" {
+		t.Fatal("Director set forwarded prefix ", prefix, " instead of /billing")
+	}
+	if tenant := req.Header.Get("X-Tenant"); tenant != "ocelot" {
+		t.Fatal("Director set tenant header ", tenant, " instead of ocelot")
+	}
+}
+
+func TestDirectorDropsSpoofedPrefix(t *testing.T) {
+	route := types.Route{ID: "plain", TargetPort: 8080, ProxiedURL: "ocelot.com/plain"}
+	req := routedRequest(t, "GET", "http://ocelot.com/plain", route)
+	req.Header.Set(routes.ForwardedPrefixHeader, "/spoofed")
+	director(req.WithContext(newTargetContext(req.Context(), newBackend(route).targets[0])))
+
+	if prefix := req.Header.Get(routes.ForwardedPrefixHeader); prefix != "" {
+		t.Fatal("Director forwarded the client's prefix ", prefix)
+	}
+}
+
+func TestDirectorDropsSpoofedSubdomain(t *testing.T) {
+	route := types.Route{ID: "exact", TargetPort: 8080, ProxiedURL: "ocelot.com", SubdomainHeader: "X-Tenant"}
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	req.Header.Set(routes.DefaultSubdomainHeader, "spoofed")
+	req.Header.Set("X-Tenant", "spoofed")
+	director(req.WithContext(newTargetContext(req.Context(), newBackend(route).targets[0])))
+
+	if req.Header.Get(routes.DefaultSubdomainHeader) != "" || req.Header.Get("X-Tenant") != "" {
+		t.Fatal("Director forwarded the client's subdomain headers ", req.Header)
+	}
+}
+
+func TestDirectorUsesExplicitUpstream(t *testing.T) {
+	route := types.Route{
+		ID: "external", ProxiedURL: "ocelot.com/ext", Upstream: "https://api.example.com:9443/base/?key=secret",
+	}
+	req := directedRequest(t, "http://ocelot.com/ext/items?page=2", route)
+
+	if req.URL.String() != "https://api.example.com:9443/base/ext/items?key=secret&page=2" {
+		t.Fatal("Director forwarded to ", req.URL, " instead of https://api.example.com:9443/base/ext/items?key=secret&page=2")
+	}
+	if req.Host != "api.example.com:9443" || req.Header.Get("X-Forwarded-Host") != "ocelot.com" {
+		t.Fatal("Director sent host ", req.Host, " forwarding ", req.Header.Get("X-Forwarded-Host"), " instead of the upstream's host")
+	}
+
+	route.PreserveHost = true
+	if req := directedRequest(t, "http://ocelot.com/ext/items", route); req.Host != "ocelot.com" {
+		t.Fatal("Director sent host ", req.Host, " instead of preserving ocelot.com")
+	}
+}
+
+// upstream starts a server answering every request with its name
+func upstream(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+}
+
+func serve(proxy Proxy, req *http.Request) (int, string) {
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, req)
+	body, _ := ioutil.ReadAll(respRec.Body)
+	return respRec.Code, string(body)
+}
+
+func TestProxyBalancesTargets(t *testing.T) {
+	blue, green := upstream("blue"), upstream("green")
+	defer blue.Close()
+	defer green.Close()
+	route := types.Route{
+		ID:         "colors",
+		ProxiedURL: "ocelot.com",
+		Targets:    []types.Target{{URL: blue.URL}, {URL: green.URL}},
+	}
+	proxy := New(nil, nil, "secret")
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		code, body := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route))
+		if code != http.StatusOK {
+			t.Fatal("Proxy returned ", code, " instead of ", http.StatusOK)
+		}
+		counts[body]++
+	}
+	if counts["blue"] != 2 || counts["green"] != 2 {
+		t.Fatal("Proxy balanced requests as ", counts, " instead of evenly")
+	}
+}
+
+// abortedWriter fails to write the body, like the connection of a client that went away
+type abortedWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w abortedWriter) Write([]byte) (int, error) {
+	return 0, errors.New("client went away")
+}
+
+func TestProxyReleasesTargetWhenClientAborts(t *testing.T) {
+	server := upstream("aborted")
+	defer server.Close()
+	route := types.Route{ID: "aborted", ProxiedURL: "ocelot.com", Upstream: server.URL}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Fatal("Proxy panicked with ", err, " instead of ", http.ErrAbortHandler)
+			}
+		}()
+		req := routedRequest(t, "GET", "http://ocelot.com/", route)
+		// the reverse proxy only aborts handlers run by a server
+		req = req.WithContext(context.WithValue(req.Context(), http.ServerContextKey, &http.Server{}))
+		proxy.ServeHTTP(abortedWriter{httptest.NewRecorder()}, req)
+	}()
+	if active := proxy.backend(&route).targets[0].Active(); active != 0 {
+		t.Fatal("Target has ", active, " active requests instead of 0 after the client aborted")
+	}
+}
+
+func TestProxyWithoutRoute(t *testing.T) {
+	if code, _ := serve(New(nil, nil, "secret"), httptest.NewRequest("GET", "http://ocelot.com/", nil)); code != http.StatusNotFound {
+		t.Fatal("Proxy returned ", code, " instead of ", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,77 @@
+package reverse
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"net/http"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// version is one version of a traffic split, balancing over its own targets
+type version struct {
+	name     string
+	percent  int
+	balancer Balancer
+}
+
+// split sends each request to a version chosen by percentage, then to one of
+// that version's targets. Versions without an available target are skipped.
+type split struct {
+	config   *types.TrafficSplit
+	versions []*version
+}
+
+// bucket places the request in one of 100 buckets, the same bucket for every
+// request carrying the same sticky key
+func (s *split) bucket(req *http.Request) int {
+	var key string
+	switch s.config.StickyOn {
+	case types.HashOnHeader:
+		key = req.Header.Get(s.config.StickyKey)
+	case types.HashOnCookie:
+		if cookie, err := req.Cookie(s.config.StickyKey); err == nil {
+			key = cookie.Value
+		}
+	}
+	if key == "" {
+		return mathrand.Intn(100)
+	}
+	return int(hashKey(key) % 100)
+}
+
+func (s *split) Next(req *http.Request) *Target {
+	bucket, start := s.bucket(req), 0
+	for i, v := range s.versions {
+		if bucket < v.percent {
+			start = i
+			break
+		}
+		bucket -= v.percent
+	}
+	for i := range s.versions {
+		if target := s.versions[(start+i)%len(s.versions)].balancer.Next(req); target != nil {
+			return target
+		}
+	}
+	return nil
+}
+
+// assign issues the sticky cookie to clients without one, so their following
+// requests stay on the version this one is sent to
+func (s *split) assign(w http.ResponseWriter, req *http.Request) {
+	if s.config.StickyOn != types.HashOnCookie {
+		return
+	}
+	if _, err := req.Cookie(s.config.StickyKey); err == nil {
+		return
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return
+	}
+	cookie := &http.Cookie{Name: s.config.StickyKey, Value: hex.EncodeToString(id), Path: "/", HttpOnly: true}
+	http.SetCookie(w, cookie)
+	req.AddCookie(cookie)
+}
@@ -0,0 +1,102 @@
+package reverse
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func canaryRoute(stable, canary string, percent int) types.Route {
+	return types.Route{
+		ID:         "canary",
+		ProxiedURL: "ocelot.com",
+		Split: &types.TrafficSplit{
+			Versions: []types.Version{
+				{Name: "stable", Percent: 100 - percent, Upstream: stable},
+				{Name: "canary", Percent: percent, Upstream: canary},
+			},
+			StickyOn:  types.HashOnHeader,
+			StickyKey: "X-User",
+		},
+	}
+}
+
+func TestSplitByPercentage(t *testing.T) {
+	b := newBackend(canaryRoute("http://stable:8080", "http://canary:8080", 20))
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", fmt.Sprint("user", i))
+		counts[b.balancer.Next(req).version]++
+	}
+	if counts["canary"] < 150 || counts["canary"] > 250 {
+		t.Fatal("Canary received ", counts["canary"], " of 1000 requests instead of about 200")
+	}
+}
+
+func TestSplitIsSticky(t *testing.T) {
+	before := newBackend(canaryRoute("http://stable:8080", "http://canary:8080", 20))
+	after := newBackend(canaryRoute("http://stable:8080", "http://canary:8080", 50))
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", fmt.Sprint("user", i))
+		first := before.balancer.Next(req).version
+		for j := 0; j < 3; j++ {
+			if version := before.balancer.Next(req).version; version != first {
+				t.Fatal("User moved from ", first, " to ", version)
+			}
+		}
+		// raising the canary's share only moves users onto the canary
+		if first == "canary" && after.balancer.Next(req).version != "canary" {
+			t.Fatal("User left the canary when its share grew")
+		}
+	}
+}
+
+func TestSplitSkipsUnavailableVersion(t *testing.T) {
+	b := newBackend(canaryRoute("http://stable:8080", "http://canary:8080", 100))
+	atomic.StoreInt32(&b.targets[1].health, unhealthy)
+	if target := b.balancer.Next(httptest.NewRequest("GET", "/", nil)); target == nil || target.version != "stable" {
+		t.Fatal("Split picked ", target, " instead of the stable version")
+	}
+}
+
+func TestSplitIssuesStickyCookie(t *testing.T) {
+	stable, canary := upstream("stable"), upstream("canary")
+	defer stable.Close()
+	defer canary.Close()
+	route := canaryRoute(stable.URL, canary.URL, 50)
+	route.Split.StickyOn, route.Split.StickyKey = types.HashOnCookie, "ocelot-version"
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, routedRequest(t, "GET", "http://ocelot.com/", route))
+	cookies := respRec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "ocelot-version" {
+		t.Fatal("Proxy issued cookies ", cookies, " instead of ocelot-version")
+	}
+	first := respRec.Body.String()
+	for i := 0; i < 5; i++ {
+		req := routedRequest(t, "GET", "http://ocelot.com/", route)
+		req.AddCookie(cookies[0])
+		respRec := httptest.NewRecorder()
+		proxy.ServeHTTP(respRec, req)
+		if body := respRec.Body.String(); body != first || len(respRec.Result().Cookies()) != 0 {
+			t.Fatal("Request with sticky cookie reached ", body, " instead of ", first)
+		}
+	}
+}
+
+func TestRouteChangeKeepsTargetHealth(t *testing.T) {
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	route := canaryRoute("http://stable:8080", "http://canary:8080", 10)
+	atomic.StoreInt32(&proxy.backend(&route).targets[1].health, unhealthy)
+
+	route = canaryRoute("http://stable:8080", "http://canary:8080", 20)
+	if target := proxy.backend(&route).targets[1]; target.Healthy() {
+		t.Fatal("Proxy forgot unhealthy target ", target.URL, " when the route changed")
+	}
+}
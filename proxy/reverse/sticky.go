@@ -0,0 +1,94 @@
+package reverse
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultAffinityCookie = "ocelot-affinity"
+
+// newSecret returns the key affinity cookies are signed with, a random one
+// when none is configured
+func newSecret(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal("Generating sticky session secret failed: ", err)
+	}
+	log.Printf("No sticky session secret set, affinity cookies will not survive restarts")
+	return key
+}
+
+func (p *proxyWrapper) sign(routeID, target string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(routeID + "|" + target))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func affinityCookie(b *backend) string {
+	if name := b.route.StickySession.CookieName; name != "" {
+		return name
+	}
+	return defaultAffinityCookie
+}
+
+// pinned returns the target named by the request's affinity cookie when it is
+// still part of the route and available. Cookies hold the signature of the
+// route and target, so they neither reveal upstreams nor can be forged. The
+// cookie is removed from the request so that it is not sent upstream.
+func (p *proxyWrapper) pinned(b *backend, req *http.Request) *Target {
+	if b.route.StickySession == nil {
+		return nil
+	}
+	name := affinityCookie(b)
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return nil
+	}
+	removeCookie(req, name)
+	for _, target := range b.targets {
+		if hmac.Equal([]byte(cookie.Value), []byte(p.sign(b.route.ID, target.URL.String()))) {
+			if target.Available() {
+				return target
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// removeCookie drops the named cookie from the request's Cookie header
+func removeCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// pin issues an affinity cookie for target, replacing one set for an earlier try
+func (p *proxyWrapper) pin(w http.ResponseWriter, b *backend, target *Target) {
+	name := affinityCookie(b)
+	var cookies []string
+	for _, cookie := range w.Header()["Set-Cookie"] {
+		if !strings.HasPrefix(cookie, name+"=") {
+			cookies = append(cookies, cookie)
+		}
+	}
+	w.Header()["Set-Cookie"] = cookies
+	cookie := &http.Cookie{Name: name, Value: p.sign(b.route.ID, target.URL.String()), Path: "/", HttpOnly: true}
+	if ttl := time.Duration(b.route.StickySession.TTL); ttl > 0 {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,87 @@
+package reverse
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// stickyRequest sends a request with the given cookies, returning the body and affinity cookie of the response
+func stickyRequest(t *testing.T, proxy Proxy, route types.Route, cookies ...*http.Cookie) (string, *http.Cookie) {
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	respRec := httptest.NewRecorder()
+	proxy.ServeHTTP(respRec, req)
+	for _, cookie := range respRec.Result().Cookies() {
+		if cookie.Name == defaultAffinityCookie {
+			return respRec.Body.String(), cookie
+		}
+	}
+	return respRec.Body.String(), nil
+}
+
+func TestStickySessions(t *testing.T) {
+	first, second := upstream("first"), upstream("second")
+	defer first.Close()
+	defer second.Close()
+	route := types.Route{
+		ID:            "sticky",
+		ProxiedURL:    "ocelot.com",
+		Targets:       []types.Target{{URL: first.URL}, {URL: second.URL}},
+		StickySession: &types.StickySession{},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+
+	pinned, cookie := stickyRequest(t, proxy, route)
+	if cookie == nil {
+		t.Fatal("Proxy did not issue an affinity cookie")
+	}
+	for i := 0; i < 4; i++ {
+		if body, renewed := stickyRequest(t, proxy, route, cookie); body != pinned || renewed != nil {
+			t.Fatal("Pinned request reached ", body, " instead of ", pinned)
+		}
+	}
+
+	forged := &http.Cookie{Name: cookie.Name, Value: cookie.Value[:len(cookie.Value)-2] + "AA"}
+	if _, renewed := stickyRequest(t, proxy, route, forged); renewed == nil {
+		t.Fatal("Proxy accepted a forged affinity cookie")
+	}
+
+	index := 0
+	if pinned == "second" {
+		index = 1
+	}
+	atomic.StoreInt32(&proxy.backend(&route).targets[index].health, unhealthy)
+	body, renewed := stickyRequest(t, proxy, route, cookie)
+	if body == pinned || renewed == nil {
+		t.Fatal("Request pinned to an unhealthy target reached ", body, " without a new affinity cookie")
+	}
+}
+
+func TestStickySessionCookieStaysAtTheProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer server.Close()
+	route := types.Route{ID: "sticky", ProxiedURL: "ocelot.com", Targets: []types.Target{{URL: server.URL}}, StickySession: &types.StickySession{}}
+	proxy := New(nil, nil, "secret")
+
+	_, cookie := stickyRequest(t, proxy, route)
+	if cookie == nil {
+		t.Fatal("Proxy did not issue an affinity cookie")
+	}
+	if decoded, _ := base64.RawURLEncoding.DecodeString(cookie.Value); strings.Contains(cookie.Value+string(decoded), server.Listener.Addr().String()) {
+		t.Fatal("Affinity cookie ", cookie.Value, " reveals the upstream ", server.URL)
+	}
+	body, renewed := stickyRequest(t, proxy, route, cookie, &http.Cookie{Name: "theme", Value: "dark"})
+	if body != "theme=dark" || renewed != nil {
+		t.Fatal("Upstream received cookies ", body, " instead of theme=dark")
+	}
+}
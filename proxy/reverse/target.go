@@ -0,0 +1,202 @@
+package reverse
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// Target is an upstream a route forwards requests to, its state is shared by
+// every request to the route and only updated atomically
+type Target struct {
+	URL    *url.URL
+	Weight int
+
+	routeID string
+	version string
+	active  int64
+	health  int32
+	// consecutive probe results, only touched by the health checker
+	successes, failures int
+	outlier             outlierState
+	breaker             *breaker
+}
+
+// Active returns the number of requests currently in flight to the target
+func (t *Target) Active() int64 {
+	return atomic.LoadInt64(&t.active)
+}
+
+// Available reports whether the target may receive requests, drained targets never do
+func (t *Target) Available() bool {
+	return t.Weight > 0 && t.Healthy() && !t.Ejected()
+}
+
+func (t *Target) acquire() {
+	atomic.AddInt64(&t.active, 1)
+}
+
+func (t *Target) release() {
+	atomic.AddInt64(&t.active, -1)
+}
+
+// backend holds the upstream state for a route, it is kept across requests
+// and only rebuilt when the route's definition changes
+type backend struct {
+	route types.Route
+	// resolved is the route of the routing index the backend was last looked up for
+	resolved  *types.Route
+	proxy     *httputil.ReverseProxy
+	targets   []*Target
+	balancer  Balancer
+	split     *split
+	mirror    *mirror
+	breaker   *breaker
+	retrier   *retrier
+	auth      *jwtValidator
+	forward   *forwardAuth
+	transport http.RoundTripper
+	// err keeps the route from being proxied when its transport cannot be built
+	err  error
+	done chan struct{}
+}
+
+func newBackend(route types.Route) *backend {
+	transport, err := newTransport(&route)
+	if err != nil {
+		log.Printf("Invalid TLS configuration for route %s: %v", route.ID, err)
+	}
+	b := &backend{
+		route:     route,
+		breaker:   newBreaker(route.ID, route.CircuitBreaker),
+		retrier:   newRetrier(route.ID, route.Retry),
+		auth:      newJWTValidator(&route),
+		forward:   newForwardAuth(&route),
+		transport: transport,
+		err:       err,
+	}
+	b.proxy = newReverseProxy(&route, b.transport)
+	b.mirror = newMirror(&route, b.transport)
+	if route.Split != nil {
+		b.split = &split{config: route.Split}
+		for i := range route.Split.Versions {
+			config := &route.Split.Versions[i]
+			targets := b.newTargets(&route, routes.VersionTargets(config), config.Name)
+			b.targets = append(b.targets, targets...)
+			b.split.versions = append(b.split.versions, &version{
+				name:     config.Name,
+				percent:  config.Percent,
+				balancer: newBalancer(route.LoadBalancing, targets),
+			})
+		}
+		b.balancer = b.split
+	} else {
+		b.targets = b.newTargets(&route, routes.Targets(&route), "")
+		b.balancer = newBalancer(route.LoadBalancing, b.targets)
+	}
+	if route.HealthCheck != nil {
+		b.done = make(chan struct{})
+		go b.healthCheck(b.done)
+	}
+	return b
+}
+
+// newTargets creates the targets of a route or of one version of its traffic split
+func (b *backend) newTargets(route *types.Route, configs []types.Target, version string) []*Target {
+	var targets []*Target
+	for _, target := range configs {
+		upstream, err := routes.ParseUpstream(target.URL)
+		if err != nil {
+			log.Printf("Skipping upstream for route %s: %v", route.ID, err)
+			continue
+		}
+		weight := 1
+		if target.Weight != nil {
+			weight = *target.Weight
+		}
+		targets = append(targets, &Target{
+			URL:     upstream,
+			Weight:  weight,
+			routeID: route.ID,
+			version: version,
+			outlier: outlierState{config: route.OutlierDetection},
+			breaker: b.breaker,
+		})
+	}
+	return targets
+}
+
+// inherit carries over the state of targets kept across a change to the route,
+// their health and outlier ejections, along with the circuit breaker and retry
+// budget, so that adjusting a route does not send traffic to targets known to
+// be down. State whose configuration changed starts afresh.
+func (b *backend) inherit(old *backend) {
+	for _, target := range b.targets {
+		for _, previous := range old.targets {
+			if previous.URL.String() == target.URL.String() {
+				atomic.StoreInt32(&target.health, atomic.LoadInt32(&previous.health))
+				target.outlier.inherit(&previous.outlier)
+			}
+		}
+	}
+	b.breaker.inherit(old.breaker)
+	b.retrier.inherit(old.retrier)
+}
+
+// stop ends the background work of a backend that is no longer used
+func (b *backend) stop() {
+	if b.done != nil {
+		close(b.done)
+	}
+	if closer, ok := b.transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// backend returns the state for route, creating it the first time the route is
+// seen and whenever its definition has changed since. Routes are compared only
+// when the routing index was rebuilt since the backend was last looked up.
+func (p *proxyWrapper) backend(route *types.Route) *backend {
+	p.mux.RLock()
+	old, ok := p.backends[route.ID]
+	current := ok && old.resolved == route
+	p.mux.RUnlock()
+	if current {
+		return old
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	old, ok = p.backends[route.ID]
+	if ok && reflect.DeepEqual(old.route, *route) {
+		old.resolved = route
+		return old
+	}
+	b := newBackend(*route)
+	b.resolved = route
+	if ok {
+		old.stop()
+		b.inherit(old)
+	}
+	p.backends[route.ID] = b
+	return b
+}
+
+type targetKey int
+
+const targetContextKey targetKey = 0
+
+func newTargetContext(ctx context.Context, target *Target) context.Context {
+	return context.WithValue(ctx, targetContextKey, target)
+}
+
+func targetFromContext(ctx context.Context) *Target {
+	target, _ := ctx.Value(targetContextKey).(*Target)
+	return target
+}
@@ -0,0 +1,125 @@
+package reverse
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/routes"
+	"github.com/ocelotconsulting/go-ocelot/types"
+	"golang.org/x/net/http2"
+)
+
+// defaultDialTimeout matches http.DefaultTransport
+const defaultDialTimeout = 30 * time.Second
+
+// Reasons an upstream request timed out, used in logs and metrics
+const (
+	timeoutDial           = "dial"
+	timeoutTLSHandshake   = "tls-handshake"
+	timeoutResponseHeader = "response-header"
+	timeoutRequest        = "request"
+)
+
+// newTransport returns the transport for a route's upstream requests, or nil
+// when the route can share http.DefaultTransport. Routes whose TLS
+// configuration cannot be loaded get a transport failing every request.
+func newTransport(route *types.Route) (http.RoundTripper, error) {
+	timeouts := route.Timeouts
+	if timeouts == nil {
+		timeouts = &types.Timeouts{}
+	}
+	var config *tls.Config
+	if route.TLS != nil {
+		var err error
+		if config, err = routes.TLSConfig(route.TLS); err != nil {
+			return failedTransport{err}, err
+		}
+	}
+	dialer := &net.Dialer{Timeout: timeouts.Dial.Or(defaultDialTimeout), KeepAlive: 30 * time.Second}
+
+	switch route.Protocol {
+	case types.ProtocolH2C:
+		return &http2.Transport{
+			AllowHTTP: true,
+			// h2c connections are plain TCP despite the name of the hook
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}, nil
+	case types.ProtocolH2:
+		return &http2.Transport{
+			TLSClientConfig: config,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, network, addr)
+			},
+		}, nil
+	}
+
+	if route.Timeouts == nil && route.TLS == nil {
+		return nil, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config != nil {
+		transport.TLSClientConfig = config
+	}
+	transport.DialContext = dialer.DialContext
+	if timeouts.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(timeouts.TLSHandshake)
+	}
+	transport.ResponseHeaderTimeout = time.Duration(timeouts.ResponseHeader)
+	return transport, nil
+}
+
+// failedTransport refuses the upstream requests of routes whose TLS
+// configuration cannot be loaded, rather than connecting without it
+type failedTransport struct {
+	err error
+}
+
+func (t failedTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// withDeadline applies the route's overall request timeout to ctx
+func withDeadline(ctx context.Context, timeouts *types.Timeouts) (context.Context, context.CancelFunc) {
+	if timeouts == nil || timeouts.Request <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeouts.Request))
+}
+
+// timeoutReason tells which timeout made an upstream request fail, or returns
+// an empty string when it did not time out
+func timeoutReason(ctx context.Context, err error) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return timeoutRequest
+	}
+	if err == nil {
+		return ""
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+		return timeoutDial
+	}
+	// the transport only reports these two as plain errors
+	switch {
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return timeoutTLSHandshake
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return timeoutResponseHeader
+	}
+	return ""
+}
+
+// gatewayTimeout answers a request whose upstream timed out
+func gatewayTimeout(w http.ResponseWriter, req *http.Request, routeID, reason string, err error) {
+	log.Printf("Upstream %s timeout for route %s: %v", reason, routeID, err)
+	upstreamTimeouts.Add(routeID+"."+reason, 1)
+	writeError(w, req, http.StatusGatewayTimeout, "Upstream "+reason+" timeout")
+}
@@ -0,0 +1,190 @@
+package reverse
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// slow starts a server that waits before answering
+func slow(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}))
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	hung := slow(time.Second)
+	defer hung.Close()
+	route := types.Route{
+		ID:         "hung",
+		ProxiedURL: "ocelot.com",
+		Upstream:   hung.URL,
+		Timeouts:   &types.Timeouts{ResponseHeader: types.Duration(20 * time.Millisecond)},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+	metric := "hung." + timeoutResponseHeader
+	upstreamTimeouts.Set(metric, new(expvar.Int))
+
+	code, body := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route))
+	if code != http.StatusGatewayTimeout || !strings.Contains(body, timeoutResponseHeader) {
+		t.Fatal("Proxy answered ", code, " with ", body, " instead of a response header timeout")
+	}
+	if count := upstreamTimeouts.Get(metric).String(); count != "1" {
+		t.Fatal("Response header timeout was counted ", count, " times instead of once")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	hung := slow(time.Second)
+	defer hung.Close()
+	route := types.Route{
+		ID:         "deadline",
+		ProxiedURL: "ocelot.com",
+		Upstream:   hung.URL,
+		Timeouts:   &types.Timeouts{Request: types.Duration(20 * time.Millisecond)},
+	}
+	proxy := New(nil, nil, "secret").(*proxyWrapper)
+
+	start := time.Now()
+	code, body := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route))
+	if code != http.StatusGatewayTimeout || !strings.Contains(body, timeoutRequest) {
+		t.Fatal("Proxy answered ", code, " with ", body, " instead of a request timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatal("Request timed out after ", elapsed, " instead of 20ms")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTimeoutReason(t *testing.T) {
+	ctx := context.Background()
+	expired, cancel := context.WithDeadline(ctx, time.Now())
+	defer cancel()
+	cases := []struct {
+		ctx    context.Context
+		err    error
+		reason string
+	}{
+		{ctx, &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, timeoutDial},
+		{ctx, errors.New("net/http: TLS handshake timeout"), timeoutTLSHandshake},
+		{ctx, errors.New("net/http: timeout awaiting response headers"), timeoutResponseHeader},
+		{expired, context.DeadlineExceeded, timeoutRequest},
+		{ctx, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ""},
+	}
+	for _, c := range cases {
+		if reason := timeoutReason(c.ctx, c.err); reason != c.reason {
+			t.Fatal("Error ", c.err, " timed out with ", reason, " instead of ", c.reason)
+		}
+	}
+}
+
+// writePEM writes PEM blocks to a new file in dir
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal("Writing ", path, " failed: ", err)
+	}
+	return path
+}
+
+// clientCertificate writes a self-signed client certificate and its key to dir
+func clientCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Generating key failed: ", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ocelot"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Creating certificate failed: ", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Encoding key failed: ", err)
+	}
+	return writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	secure := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	secure.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	secure.StartTLS()
+	defer secure.Close()
+
+	dir, err := ioutil.TempDir("", "upstream-tls")
+	if err != nil {
+		t.Fatal("Creating temporary directory failed: ", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := writePEM(t, dir, "ca.pem", "CERTIFICATE", secure.Certificate().Raw)
+	cert, key := clientCertificate(t, dir)
+
+	cases := []struct {
+		config *types.UpstreamTLS
+		code   int
+		body   string
+	}{
+		{nil, http.StatusBadGateway, ""},
+		{&types.UpstreamTLS{CAFile: ca}, http.StatusOK, "anonymous"},
+		{&types.UpstreamTLS{CAFile: ca, ServerName: "example.com"}, http.StatusOK, "anonymous"},
+		{&types.UpstreamTLS{CAFile: ca, ServerName: "unknown.com"}, http.StatusBadGateway, ""},
+		{&types.UpstreamTLS{InsecureSkipVerify: true}, http.StatusOK, "anonymous"},
+		{&types.UpstreamTLS{CAFile: ca, CertFile: cert, KeyFile: key}, http.StatusOK, "ocelot"},
+	}
+	for _, c := range cases {
+		route := types.Route{ID: "secure", ProxiedURL: "ocelot.com", Upstream: secure.URL, TLS: c.config}
+		proxy := New(nil, nil, "secret").(*proxyWrapper)
+		if code, body := serve(proxy, routedRequest(t, "GET", "http://ocelot.com/", route)); code != c.code || body != c.body {
+			t.Fatal("Proxy answered ", code, " with ", body, " instead of ", c.code, " with ", c.body, " for ", c.config)
+		}
+	}
+
+	// a CA bundle removed after the route was validated fails the route rather than being skipped
+	removed := writePEM(t, dir, "removed.pem", "CERTIFICATE", secure.Certificate().Raw)
+	route := types.Route{ID: "secure", ProxiedURL: "ocelot.com", Upstream: secure.URL, TLS: &types.UpstreamTLS{CAFile: removed, InsecureSkipVerify: true}}
+	req := routedRequest(t, "GET", "http://ocelot.com/", route)
+	os.Remove(removed)
+	if code, _ := serve(New(nil, nil, "secret"), req); code != http.StatusBadGateway {
+		t.Fatal("Proxy answered ", code, " without its CA bundle instead of ", http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,102 @@
+package reverse
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// webSocketIdleTimeout returns how long a route's WebSocket connections may be idle, 0 for no limit
+func webSocketIdleTimeout(route *types.Route) time.Duration {
+	if route.WebSocket == nil {
+		return 0
+	}
+	return time.Duration(route.WebSocket.IdleTimeout)
+}
+
+// isWebSocket reports whether the request asks to upgrade to a WebSocket
+func isWebSocket(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// webSockets returns the count of open WebSocket connections of a route, kept
+// across changes to the route since connections outlive its backend
+func (p *proxyWrapper) webSockets(routeID string) *int64 {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	count, ok := p.upgrades[routeID]
+	if !ok {
+		count = new(int64)
+		p.upgrades[routeID] = count
+	}
+	return count
+}
+
+// openWebSocket counts a new WebSocket connection for the route, returning
+// false when the route already has as many as it allows
+func (p *proxyWrapper) openWebSocket(b *backend) bool {
+	count := p.webSockets(b.route.ID)
+	open := atomic.AddInt64(count, 1)
+	if config := b.route.WebSocket; config != nil && config.MaxConnections > 0 && open > int64(config.MaxConnections) {
+		atomic.AddInt64(count, -1)
+		return false
+	}
+	webSocketConnections.Add(b.route.ID, 1)
+	return true
+}
+
+func (p *proxyWrapper) closeWebSocket(b *backend) {
+	atomic.AddInt64(p.webSockets(b.route.ID), -1)
+	webSocketConnections.Add(b.route.ID, -1)
+}
+
+// upgradeWriter hands the reverse proxy client connections that close after
+// being idle for timeout
+type upgradeWriter struct {
+	http.ResponseWriter
+	routeID string
+	timeout time.Duration
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("Connection cannot be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil || w.timeout <= 0 {
+		return conn, rw, err
+	}
+	return &idleConn{Conn: conn, routeID: w.routeID, timeout: w.timeout}, rw, nil
+}
+
+// idleConn pushes its deadline back on every read and write. The reverse
+// proxy reads from the client while writing the upstream's messages to it,
+// so the pending read times out once neither side has sent anything.
+type idleConn struct {
+	net.Conn
+	routeID string
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Read(b)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		log.Printf("Closing idle WebSocket connection for route %s", c.routeID)
+		webSocketIdleClosed.Add(c.routeID, 1)
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func validateRateLimit(route *types.Route) error {
+	limit := route.RateLimit
+	if limit == nil {
+		return nil
+	}
+	if limit.Requests <= 0 {
+		return fmt.Errorf("Rate limit must allow at least one request")
+	}
+	switch limit.KeyOn {
+	case "", types.HashOnIP:
+	case types.HashOnHeader, types.HashOnCookie, types.HashOnQuery:
+		if limit.Key == "" {
+			return fmt.Errorf("Rate limiting on a %s needs a key", limit.KeyOn)
+		}
+	default:
+		return fmt.Errorf("Unknown rate limit keyOn %s", limit.KeyOn)
+	}
+	return nil
+}
+
+func validateAuth(route *types.Route) error {
+	if auth := route.Auth; auth != nil && auth.Type != types.AuthAPIKey && auth.Type != types.AuthBasic {
+		return fmt.Errorf("Unknown auth type %s", auth.Type)
+	}
+	return nil
+}
+
+func validateForwardAuth(route *types.Route) error {
+	if forward := route.ForwardAuth; forward != nil {
+		if _, err := ParseUpstream(forward.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateJWT(route *types.Route) error {
+	jwt := route.JWT
+	if jwt == nil {
+		return nil
+	}
+	if jwt.KeysFile == "" && jwt.JWKSURL == "" {
+		return fmt.Errorf("JWT validation needs a keysFile or jwksURL")
+	}
+	if jwt.RefreshInterval != 0 && time.Duration(jwt.RefreshInterval) < time.Minute {
+		return fmt.Errorf("JWKS refreshInterval must be at least 1m")
+	}
+	if jwt.JWKSURL != "" {
+		if _, err := ParseUpstream(jwt.JWKSURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
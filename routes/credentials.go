@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// Credentials accessor
+func (r *routeWrapper) Credentials() map[string]types.Credential {
+	r.routes.mux.Lock()
+	defer r.routes.mux.Unlock()
+	return r.routes.credentials
+}
+
+// syncCredentialsFromRedis replaces the credentials with those in redis, so that removed credentials stop working
+func (r *routeWrapper) syncCredentialsFromRedis() {
+	credentialsJSON, err := r.cache.GetAll("credentials")
+	if err != nil {
+		log.Printf("Error loading credentials: %v", err)
+		return
+	}
+	credentials := make(map[string]types.Credential)
+	for _, credentialStr := range credentialsJSON {
+		var credential types.Credential
+		if err := json.Unmarshal([]byte(credentialStr), &credential); err != nil {
+			log.Print("Error syncing credentials", err)
+			return
+		}
+		credentials[credential.ID] = credential
+	}
+
+	r.routes.mux.Lock()
+	defer r.routes.mux.Unlock()
+	r.routes.credentials = credentials
+}
+
+// copyCredentials returns a copy of the credentials to change, the accessor's map is never modified
+func (r *routeWrapper) copyCredentials() map[string]types.Credential {
+	credentials := make(map[string]types.Credential, len(r.routes.credentials)+1)
+	for id, credential := range r.routes.credentials {
+		credentials[id] = credential
+	}
+	return credentials
+}
+
+// UpdateCredential stores a credential both in redis and in memory
+func (r *routeWrapper) UpdateCredential(credential types.Credential) error {
+	json, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	if cacheError := r.cache.SetField("credentials", credential.ID, string(json)); cacheError != nil {
+		log.Printf("Error storing credential in cache: %v", cacheError)
+		return fmt.Errorf("Error storing credential in cache: %v", cacheError)
+	}
+	r.routes.mux.Lock()
+	defer r.routes.mux.Unlock()
+	credentials := r.copyCredentials()
+	credentials[credential.ID] = credential
+	r.routes.credentials = credentials
+	log.Printf("Stored credential in cache: %s", credential.ID)
+	return nil
+}
+
+// DeleteCredential removes a credential both in redis and in memory
+func (r *routeWrapper) DeleteCredential(id string) (int, error) {
+	r.routes.mux.Lock()
+	defer r.routes.mux.Unlock()
+	if _, ok := r.routes.credentials[id]; !ok {
+		return http.StatusNotFound, fmt.Errorf("Credential not found for %s", id)
+	}
+	credentials := r.copyCredentials()
+	delete(credentials, id)
+	r.routes.credentials = credentials
+	if cacheError := r.cache.DeleteField("credentials", id); cacheError != nil {
+		log.Printf("Error removing credential from cache: %v", cacheError)
+		return http.StatusInternalServerError, fmt.Errorf("Error removing credential from cache: %v", cacheError)
+	}
+	log.Printf("Removed credential from cache: %s", id)
+	return http.StatusNoContent, nil
+}
@@ -0,0 +1,265 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// Index is a compiled lookup table for routes. Routes are grouped by host and
+// the paths for each host are kept in a radix tree, so resolving a request
+// costs a map lookup plus a walk proportional to the length of its path,
+// regardless of how many routes are registered.
+//
+// A route path only matches a request path at a segment boundary: the route
+// /app matches /app and /app/settings but not /application. A trailing slash
+// on a route path is ignored, so /app/ and /app are the same route.
+//
+// Several routes may share a host and path when they have predicates on the
+// method, headers, query or cookies of a request. They are evaluated in order
+// of priority, then by number of predicates, then by ID, and the first whose
+// predicates all hold is used.
+//
+// Route paths may also be templates such as /users/{id}/orders or regular
+// expressions, depending on the route's pattern type. Patterns are stored at
+// the literal prefix they start with and evaluated when a lookup passes there.
+//
+// The first label of a route host may be a wildcard, either * or a named
+// {param}, matching exactly one label of the request host. Wildcard hosts are
+// only consulted when no route for the exact host matches.
+type Index struct {
+	hosts     map[string]*node
+	wildcards map[string]*node
+	maxDepth  int
+}
+
+// compiledRoute is a route along with the parts of it parsed when the index is built
+type compiledRoute struct {
+	route          *types.Route
+	subdomainParam string
+	pattern        *regexp.Regexp
+	predicates     []compiledPredicate
+	rewrite        *regexp.Regexp
+}
+
+// node is an edge in the radix tree. The prefix is the label on the edge
+// leading to the node, routes holds the literal routes ending exactly here and
+// patterns the pattern routes whose literal prefix ends here, both in the
+// order they are evaluated.
+type node struct {
+	prefix   string
+	children map[byte]*node
+	routes   []*compiledRoute
+	patterns []*compiledRoute
+}
+
+// NewIndex compiles the given routes into an Index. Only the first maxDepth
+// segments of a request path are considered when matching, 0 means no limit.
+func NewIndex(routes map[string]types.Route, maxDepth int) *Index {
+	index := &Index{hosts: make(map[string]*node), wildcards: make(map[string]*node), maxDepth: maxDepth}
+	// Add routes in a stable order so conflicting routes always resolve the same way
+	ids := make([]string, 0, len(routes))
+	for id := range routes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		route := routes[id]
+		if err := index.add(&route); err != nil {
+			log.Printf("Error compiling route %s: %v", id, err)
+		}
+	}
+	return index
+}
+
+func (i *Index) add(route *types.Route) error {
+	if route.ProxiedURL == "" {
+		return nil
+	}
+	host, path := splitHostPath(route.ProxiedURL)
+	pattern, prefix, err := compilePattern(route.PatternType, path)
+	if err != nil {
+		return err
+	}
+	predicates, err := compilePredicates(route.Predicates)
+	if err != nil {
+		return err
+	}
+	rewrite, err := compileRewrite(route.Rewrite)
+	if err != nil {
+		return err
+	}
+	if err := validateFeatures(route); err != nil {
+		return err
+	}
+	compiled := &compiledRoute{route: route, pattern: pattern, predicates: predicates, rewrite: rewrite}
+	trees := i.hosts
+	if label, suffix, ok := wildcardHost(host); ok {
+		if label != "*" {
+			compiled.subdomainParam = label[1 : len(label)-1]
+		}
+		trees, host = i.wildcards, suffix
+	}
+	root, ok := trees[host]
+	if !ok {
+		root = &node{}
+		trees[host] = root
+	}
+	n := root.find(prefix)
+	if pattern != nil {
+		n.patterns = insertOrdered(n.patterns, compiled)
+	} else {
+		n.routes = insertOrdered(n.routes, compiled)
+	}
+	return nil
+}
+
+// lookup returns the route registered for host matching the longest part of
+// path, along with the length of that part and any parameters it captured.
+func (i *Index) lookup(host, path string, req *http.Request) (*compiledRoute, int, map[string]string) {
+	if root, ok := i.hosts[host]; ok {
+		return root.longestPrefix(truncatePath(path, i.maxDepth), req)
+	}
+	return nil, -1, nil
+}
+
+// lookupWildcard is like lookup for routes with a wildcard host, it also returns the matched subdomain
+func (i *Index) lookupWildcard(host, path string, req *http.Request) (*compiledRoute, int, map[string]string, string) {
+	dot := strings.Index(host, ".")
+	if dot <= 0 {
+		return nil, -1, nil, ""
+	}
+	if root, ok := i.wildcards[host[dot+1:]]; ok {
+		route, length, params := root.longestPrefix(truncatePath(path, i.maxDepth), req)
+		return route, length, params, host[:dot]
+	}
+	return nil, -1, nil, ""
+}
+
+// wildcardHost splits a host like *.example.com or {tenant}.example.com into its wildcard label and suffix
+func wildcardHost(host string) (string, string, bool) {
+	dot := strings.Index(host, ".")
+	if dot <= 0 {
+		return "", "", false
+	}
+	label := host[:dot]
+	if label == "*" || (len(label) > 2 && strings.HasPrefix(label, "{") && strings.HasSuffix(label, "}")) {
+		return label, host[dot+1:], true
+	}
+	return "", "", false
+}
+
+// truncatePath limits path to its first maxDepth segments
+func truncatePath(path string, maxDepth int) string {
+	if maxDepth <= 0 {
+		return path
+	}
+	segments := 0
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' {
+			segments++
+			if segments > maxDepth {
+				return path[:i]
+			}
+		}
+	}
+	return path
+}
+
+// atBoundary reports whether a match of length matched ends a path segment of key
+func atBoundary(key string, matched int) bool {
+	return matched == len(key) || key[matched] == '/'
+}
+
+func splitHostPath(url string) (string, string) {
+	if slash := strings.Index(url, "/"); slash >= 0 {
+		return url[:slash], url[slash:]
+	}
+	return url, ""
+}
+
+func commonPrefixLength(a, b string) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+	for i := 0; i < max; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return max
+}
+
+// find returns the node for key, splitting edges and adding nodes as needed
+func (n *node) find(key string) *node {
+	for {
+		if key == "" {
+			return n
+		}
+		if n.children == nil {
+			n.children = make(map[byte]*node)
+		}
+		child, ok := n.children[key[0]]
+		if !ok {
+			child = &node{prefix: key}
+			n.children[key[0]] = child
+			return child
+		}
+		common := commonPrefixLength(key, child.prefix)
+		if common < len(child.prefix) {
+			// Split the edge so the shared part becomes its own node
+			split := &node{
+				prefix:   child.prefix[:common],
+				children: map[byte]*node{child.prefix[common]: child},
+			}
+			child.prefix = child.prefix[common:]
+			n.children[key[0]] = split
+			child = split
+		}
+		key = key[common:]
+		n = child
+	}
+}
+
+// longestPrefix walks the tree along key and returns the route matching the
+// longest part of it whose predicates accept req. Literal routes win over
+// patterns matching the same length.
+func (n *node) longestPrefix(key string, req *http.Request) (*compiledRoute, int, map[string]string) {
+	var best *compiledRoute
+	var bestParams map[string]string
+	bestLength := -1
+	consider := func(n *node, matched int) {
+		if atBoundary(key, matched) && matched >= bestLength {
+			for _, route := range n.routes {
+				if route.matches(req) {
+					best, bestLength, bestParams = route, matched, nil
+					break
+				}
+			}
+		}
+		for _, pattern := range n.patterns {
+			length, params, ok := pattern.match(key)
+			if ok && (length > bestLength || (length == bestLength && pattern.rank() < best.rank())) && pattern.matches(req) {
+				best, bestLength, bestParams = pattern, length, params
+			}
+		}
+	}
+	consider(n, 0)
+	matched, rest := 0, key
+	for rest != "" {
+		child, ok := n.children[rest[0]]
+		if !ok || !strings.HasPrefix(rest, child.prefix) {
+			break
+		}
+		rest = rest[len(child.prefix):]
+		matched += len(child.prefix)
+		consider(child, matched)
+		n = child
+	}
+	return best, bestLength, bestParams
+}
@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// compilePattern turns the path of a route into a regular expression according
+// to the route's pattern type. It also returns the literal prefix every path
+// matched by the expression starts with, which is where the pattern is kept in
+// the radix tree. Prefix routes have no expression.
+func compilePattern(patternType, path string) (*regexp.Regexp, string, error) {
+	switch patternType {
+	case "", types.PatternPrefix:
+		return nil, strings.TrimRight(path, "/"), nil
+	case types.PatternTemplate:
+		return compileTemplate(strings.TrimRight(path, "/"))
+	case types.PatternRegex:
+		pattern, err := regexp.Compile(fmt.Sprintf("^(?:%s)", path))
+		if err != nil {
+			return nil, "", err
+		}
+		prefix, _ := pattern.LiteralPrefix()
+		return pattern, prefix, nil
+	}
+	return nil, "", fmt.Errorf("Unknown pattern type %s", patternType)
+}
+
+// compileTemplate converts a path like /users/{id}/orders into a regular
+// expression where every {param} captures the text up to the next slash
+func compileTemplate(path string) (*regexp.Regexp, string, error) {
+	var expression []string
+	prefix := ""
+	rest := path
+	for {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			expression = append(expression, regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.Index(rest[open:], "}")
+		if end < 0 {
+			return nil, "", fmt.Errorf("Unterminated parameter in %s", path)
+		}
+		name := rest[open+1 : open+end]
+		if name == "" || strings.Contains(name, "/") {
+			return nil, "", fmt.Errorf("Invalid parameter name %q in %s", name, path)
+		}
+		if len(expression) == 0 {
+			prefix = rest[:open]
+		}
+		expression = append(expression, regexp.QuoteMeta(rest[:open]), fmt.Sprintf("(?P<%s>[^/]+)", name))
+		rest = rest[open+end+1:]
+	}
+	if len(expression) == 1 {
+		prefix = path
+	}
+	pattern, err := regexp.Compile("^" + strings.Join(expression, ""))
+	if err != nil {
+		return nil, "", err
+	}
+	return pattern, prefix, nil
+}
+
+// match reports whether a pattern route matches path, returning the length
+// of the match and the parameters it captured
+func (c *compiledRoute) match(path string) (int, map[string]string, bool) {
+	loc := c.pattern.FindStringSubmatchIndex(path)
+	if loc == nil {
+		return 0, nil, false
+	}
+	// Templates follow the same segment boundary rule as prefixes, regexes are left to their author
+	if c.route.PatternType == types.PatternTemplate && !atBoundary(path, loc[1]) {
+		return 0, nil, false
+	}
+	params := make(map[string]string)
+	for i, name := range c.pattern.SubexpNames() {
+		if name != "" && loc[2*i] >= 0 {
+			params[name] = path[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return loc[1], params, true
+}
+
+// rank orders routes matching the same length of a path, lower ranks win
+func (c *compiledRoute) rank() int {
+	switch c.route.PatternType {
+	case types.PatternTemplate:
+		return 1
+	case types.PatternRegex:
+		return 2
+	}
+	return 0
+}
+
+// Validate checks that a route can be compiled into the index
+func Validate(route types.Route) error {
+	if route.ID == "" {
+		return fmt.Errorf("Route is missing an id")
+	}
+	_, path := splitHostPath(route.ProxiedURL)
+	if _, _, err := compilePattern(route.PatternType, path); err != nil {
+		return err
+	}
+	if _, err := compilePredicates(route.Predicates); err != nil {
+		return err
+	}
+	if _, err := compileRewrite(route.Rewrite); err != nil {
+		return err
+	}
+	return validateFeatures(&route)
+}
+
+// featureValidators check the configuration of each of a route's features
+var featureValidators = []func(*types.Route) error{
+	validateProtocol, validateTargets, validateLoadBalancing, validateSplit, validateMirror, validateTLS, validateRetry,
+	validateRateLimit, validateAuth, validateForwardAuth, validateJWT, validateCache, validateCompression,
+}
+
+func validateFeatures(route *types.Route) error {
+	for _, validate := range featureValidators {
+		if err := validate(route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
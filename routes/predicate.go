@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// compiledPredicate is a predicate with its regular expression compiled
+type compiledPredicate struct {
+	types.Predicate
+	methods []string
+	pattern *regexp.Regexp
+}
+
+func compilePredicates(predicates []types.Predicate) ([]compiledPredicate, error) {
+	var compiled []compiledPredicate
+	for _, predicate := range predicates {
+		c := compiledPredicate{Predicate: predicate}
+		switch predicate.Type {
+		case types.PredicateMethod:
+			for _, method := range strings.Split(predicate.Value, ",") {
+				if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+					c.methods = append(c.methods, method)
+				}
+			}
+			if len(c.methods) == 0 {
+				return nil, fmt.Errorf("Method predicate needs at least one method")
+			}
+		case types.PredicateHeader, types.PredicateQuery, types.PredicateCookie:
+			if predicate.Name == "" {
+				return nil, fmt.Errorf("%s predicate needs a name", predicate.Type)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown predicate type %s", predicate.Type)
+		}
+		if predicate.Regex != "" {
+			pattern, err := regexp.Compile(predicate.Regex)
+			if err != nil {
+				return nil, err
+			}
+			c.pattern = pattern
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// values returns the values of the request attribute the predicate looks at
+func (p *compiledPredicate) values(req *http.Request) []string {
+	switch p.Type {
+	case types.PredicateMethod:
+		return []string{req.Method}
+	case types.PredicateHeader:
+		return req.Header[http.CanonicalHeaderKey(p.Name)]
+	case types.PredicateQuery:
+		return req.URL.Query()[p.Name]
+	case types.PredicateCookie:
+		if cookie, err := req.Cookie(p.Name); err == nil {
+			return []string{cookie.Value}
+		}
+	}
+	return nil
+}
+
+// accepts reports whether a single value of the request satisfies the predicate
+func (p *compiledPredicate) accepts(value string) bool {
+	switch {
+	case p.Type == types.PredicateMethod:
+		for _, method := range p.methods {
+			if method == value {
+				return true
+			}
+		}
+		return false
+	case p.pattern != nil:
+		return p.pattern.MatchString(value)
+	case p.Value != "":
+		return p.Value == value
+	}
+	// with neither a value nor a regex the attribute only has to be present
+	return true
+}
+
+func (p *compiledPredicate) matches(req *http.Request) bool {
+	values := p.values(req)
+	for _, value := range values {
+		if p.accepts(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the request satisfies all of the route's predicates
+func (c *compiledRoute) matches(req *http.Request) bool {
+	for i := range c.predicates {
+		if !c.predicates[i].matches(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// before orders routes sharing a host and path: higher priority first, then
+// routes with more predicates since they are more specific, then by ID
+func (c *compiledRoute) before(other *compiledRoute) bool {
+	if c.route.Priority != other.route.Priority {
+		return c.route.Priority > other.route.Priority
+	}
+	if len(c.predicates) != len(other.predicates) {
+		return len(c.predicates) > len(other.predicates)
+	}
+	return c.route.ID < other.route.ID
+}
+
+// insertOrdered adds route to a list kept in the order routes are evaluated
+func insertOrdered(routes []*compiledRoute, route *compiledRoute) []*compiledRoute {
+	i := 0
+	for i < len(routes) && routes[i].before(route) {
+		i++
+	}
+	routes = append(routes, nil)
+	copy(routes[i+1:], routes[i:])
+	routes[i] = route
+	return routes
+}
@@ -1,43 +1,120 @@
 package routes
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"net/http"
 	"strings"
 
 	"github.com/ocelotconsulting/go-ocelot/types"
 )
 
-// Handler for serving requests
-func findRoute(key string, routes map[string]types.Route) *types.Route {
-	for _, route := range routes {
-		if route.ProxiedURL == key {
-			return &route
+// DefaultSubdomainHeader carries the subdomain matched by a wildcard host to the upstream
+const DefaultSubdomainHeader = "X-Forwarded-Subdomain"
+
+// Match is a route resolved for a request along with what was captured while matching it
+type Match struct {
+	Route     *types.Route
+	Subdomain string
+	Params    map[string]string
+	// Prefix is the part of the request path matched by the route
+	Prefix string
+
+	compiled *compiledRoute
+}
+
+// Expand replaces references like {id} in s with the parameters captured by
+// the match. References to unknown parameters and regular expression group
+// references like ${id} are left as they are.
+func (m *Match) Expand(s string) string {
+	return m.expand(s, func(value string) string { return value })
+}
+
+// expand is Expand with the parameter values passed through quote first
+func (m *Match) expand(s string, quote func(string) string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	var expanded bytes.Buffer
+	for {
+		open := strings.Index(s, "{")
+		if open < 0 {
+			break
 		}
+		end := strings.Index(s[open:], "}")
+		if end < 0 {
+			break
+		}
+		value, ok := m.Params[s[open+1:open+end]]
+		if ok && (open == 0 || s[open-1] != '$') {
+			expanded.WriteString(s[:open])
+			expanded.WriteString(quote(value))
+		} else {
+			expanded.WriteString(s[:open+end+1])
+		}
+		s = s[open+end+1:]
 	}
-	return nil
+	expanded.WriteString(s)
+	return expanded.String()
 }
 
-func findRouteByPath(url string, pathDepth int, routes map[string]types.Route) *types.Route {
-	if pathDepth == 0 {
-		return nil
+// SubdomainHeader returns the header the matched subdomain is forwarded in, if any
+func (m *Match) SubdomainHeader() string {
+	if m.Subdomain == "" {
+		return ""
+	}
+	if m.Route.SubdomainHeader != "" {
+		return m.Route.SubdomainHeader
+	}
+	return DefaultSubdomainHeader
+}
+
+type contextKey int
+
+const matchKey contextKey = 0
+
+// NewContext returns a copy of ctx carrying the resolved match
+func NewContext(ctx context.Context, match *Match) context.Context {
+	return context.WithValue(ctx, matchKey, match)
+}
+
+// FromContext returns the match stored in ctx by NewContext, or nil
+func FromContext(ctx context.Context) *Match {
+	match, _ := ctx.Value(matchKey).(*Match)
+	return match
+}
+
+func newMatch(route *compiledRoute, subdomain string, params map[string]string, prefix string) *Match {
+	match := &Match{Route: route.route, Subdomain: subdomain, Params: make(map[string]string), Prefix: prefix, compiled: route}
+	for name, value := range params {
+		match.Params[name] = value
 	}
-	key := strings.Join(strings.SplitN(url, "/", pathDepth), "/")
-	log.Printf("Searching for route with key %s", key)
-	if route := findRoute(key, routes); route != nil {
-		return route
-	} else if route := findRoute(fmt.Sprintf("www.%s", key), routes); route != nil {
-		return route
-	} else {
-		return findRouteByPath(key, pathDepth-1, routes)
+	if route.subdomainParam != "" {
+		match.Params[route.subdomainParam] = subdomain
 	}
+	return match
 }
 
 //ResolveRoute helps the proxy find a route for the incoming request
-func ResolveRoute(url, host string, routes map[string]types.Route) *types.Route {
-	url = strings.Split(url, "?")[0]
-	if closestRoute := findRouteByPath(fmt.Sprintf("%s%s", host, url), 4, routes); closestRoute != nil {
-		return closestRoute
+func ResolveRoute(req *http.Request, index *Index) *Match {
+	if index == nil {
+		return nil
+	}
+	host, url := req.Host, ""
+	if req.URL.Path != "/" {
+		url = req.URL.Path
+	}
+	route, length, params := index.lookup(host, url, req)
+	if wwwRoute, wwwLength, wwwParams := index.lookup(fmt.Sprintf("www.%s", host), url, req); wwwLength > length {
+		route, length, params = wwwRoute, wwwLength, wwwParams
+	}
+	if route != nil {
+		return newMatch(route, "", params, url[:length])
+	}
+	// Exact hosts always win, wildcard hosts are only tried when nothing else matched
+	if route, length, params, subdomain := index.lookupWildcard(host, url, req); route != nil {
+		return newMatch(route, subdomain, params, url[:length])
 	}
 	return nil
 }
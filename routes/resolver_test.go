@@ -0,0 +1,377 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func setupIndex() *Index {
+	routes := make(map[string]types.Route)
+	routes["test"] = types.Route{ID: "test", ProxiedURL: "test.ocelot.com", TargetPort: 8080}
+	routes["api"] = types.Route{ID: "api", ProxiedURL: "test.ocelot.com/api", TargetPort: 8080}
+	routes["apiv2"] = types.Route{ID: "apiv2", ProxiedURL: "test.ocelot.com/api/v2", TargetPort: 8080}
+	routes["www"] = types.Route{ID: "www", ProxiedURL: "www.other.com", TargetPort: 8080}
+	routes["wwwpath"] = types.Route{ID: "wwwpath", ProxiedURL: "www.test.ocelot.com/api/v2/users", TargetPort: 8080}
+	return NewIndex(routes, 0)
+}
+
+func newRequest(method, url, host string) *http.Request {
+	req := httptest.NewRequest(method, "/"+strings.TrimPrefix(url, "/"), nil)
+	req.Host = host
+	return req
+}
+
+// resolve returns the match for a GET request to host and url
+func resolve(url, host string, index *Index) *Match {
+	return ResolveRoute(newRequest("GET", url, host), index)
+}
+
+// resolveID returns the ID of the route resolved for host and url, or an empty string
+func resolveID(url, host string, index *Index) string {
+	if match := resolve(url, host, index); match != nil {
+		return match.Route.ID
+	}
+	return ""
+}
+
+func TestResolveRoute(t *testing.T) {
+	index := setupIndex()
+	cases := []struct {
+		host, url, expected string
+	}{
+		{"test.ocelot.com", "", "test"},
+		{"test.ocelot.com", "/unknown", "test"},
+		{"test.ocelot.com", "/api", "api"},
+		{"test.ocelot.com", "/api/v1/users?id=1", "api"},
+		{"test.ocelot.com", "/apiary", "test"},
+		{"test.ocelot.com", "/api/v2beta", "api"},
+		{"test.ocelot.com", "/api/v2", "apiv2"},
+		{"test.ocelot.com", "/api/v2/users", "wwwpath"},
+		{"other.com", "/anything", "www"},
+		{"www.other.com", "", "www"},
+		{"missing.ocelot.com", "/api", ""},
+	}
+	for _, c := range cases {
+		if id := resolveID(c.url, c.host, index); id != c.expected {
+			t.Fatal("Resolved ", c.host, c.url, " to ", id, " instead of ", c.expected)
+		}
+	}
+}
+
+func TestResolveRouteNilIndex(t *testing.T) {
+	if match := ResolveRoute(newRequest("GET", "/api", "test.ocelot.com"), nil); match != nil {
+		t.Fatal("Resolved route ", match.Route.ID, " without an index")
+	}
+}
+
+func TestIndexSplitsEdges(t *testing.T) {
+	routes := map[string]types.Route{
+		"team":  {ID: "team", ProxiedURL: "ocelot.com/team"},
+		"teams": {ID: "teams", ProxiedURL: "ocelot.com/teams"},
+		"tea":   {ID: "tea", ProxiedURL: "ocelot.com/tea"},
+	}
+	index := NewIndex(routes, 0)
+	for url, expected := range map[string]string{"/tea": "tea", "/team": "team", "/teams": "teams"} {
+		if id := resolveID(url, "ocelot.com", index); id != expected {
+			t.Fatal("Resolved ", url, " to ", id, " instead of ", expected)
+		}
+	}
+}
+
+func TestResolveRouteTrailingSlash(t *testing.T) {
+	routes := map[string]types.Route{
+		"app": {ID: "app", ProxiedURL: "ocelot.com/app/"},
+	}
+	index := NewIndex(routes, 0)
+	for _, url := range []string{"/app", "/app/", "/app/settings"} {
+		if id := resolveID(url, "ocelot.com", index); id != "app" {
+			t.Fatal("Resolved ", url, " to ", id, " instead of app")
+		}
+	}
+	if id := resolveID("/application", "ocelot.com", index); id != "" {
+		t.Fatal("Resolved /application to ", id, " instead of no route")
+	}
+}
+
+func TestResolveRouteDepth(t *testing.T) {
+	routes := map[string]types.Route{
+		"deep": {ID: "deep", ProxiedURL: "api.ocelot.com/a/b/c/d"},
+		"root": {ID: "root", ProxiedURL: "api.ocelot.com"},
+	}
+	if id := resolveID("/a/b/c/d/e", "api.ocelot.com", NewIndex(routes, 0)); id != "deep" {
+		t.Fatal("Resolved /a/b/c/d/e to ", id, " instead of deep")
+	}
+	if id := resolveID("/a/b/c/d", "api.ocelot.com", NewIndex(routes, 4)); id != "deep" {
+		t.Fatal("Resolved /a/b/c/d to ", id, " instead of deep with a depth of 4")
+	}
+	if id := resolveID("/a/b/c/d", "api.ocelot.com", NewIndex(routes, 3)); id != "root" {
+		t.Fatal("Resolved /a/b/c/d to ", id, " instead of root with a depth of 3")
+	}
+}
+
+func TestResolveRouteWildcardHost(t *testing.T) {
+	routes := map[string]types.Route{
+		"preview":    {ID: "preview", ProxiedURL: "*.preview.ocelot.com"},
+		"exact":      {ID: "exact", ProxiedURL: "main.preview.ocelot.com"},
+		"tenant":     {ID: "tenant", ProxiedURL: "{tenant}.ocelot.com/app"},
+		"tenantRoot": {ID: "tenantRoot", ProxiedURL: "ocelot.com"},
+	}
+	index := NewIndex(routes, 0)
+
+	match := resolve("/orders", "pr-42.preview.ocelot.com", index)
+	if match == nil || match.Route.ID != "preview" || match.Subdomain != "pr-42" {
+		t.Fatal("Resolved pr-42.preview.ocelot.com to ", match, " instead of preview")
+	}
+	if header := match.SubdomainHeader(); header != DefaultSubdomainHeader {
+		t.Fatal("Subdomain header was ", header, " instead of ", DefaultSubdomainHeader)
+	}
+	if id := resolveID("/orders", "main.preview.ocelot.com", index); id != "exact" {
+		t.Fatal("Resolved main.preview.ocelot.com to ", id, " instead of exact")
+	}
+	if id := resolveID("", "a.b.preview.ocelot.com", index); id != "" {
+		t.Fatal("Resolved a.b.preview.ocelot.com to ", id, " instead of no route")
+	}
+
+	match = resolve("/app/users", "acme.ocelot.com", index)
+	if match == nil || match.Route.ID != "tenant" || match.Params["tenant"] != "acme" {
+		t.Fatal("Resolved acme.ocelot.com/app/users to ", match, " instead of tenant acme")
+	}
+	if id := resolveID("/other", "acme.ocelot.com", index); id != "" {
+		t.Fatal("Resolved acme.ocelot.com/other to ", id, " instead of no route")
+	}
+}
+
+func TestResolveRouteExactBeatsWildcard(t *testing.T) {
+	routes := map[string]types.Route{
+		"wildcard": {ID: "wildcard", ProxiedURL: "*.ocelot.com/api/v2"},
+		"exact":    {ID: "exact", ProxiedURL: "www.ocelot.com"},
+	}
+	match := resolve("/api/v2", "www.ocelot.com", NewIndex(routes, 0))
+	if match == nil || match.Route.ID != "exact" || match.SubdomainHeader() != "" {
+		t.Fatal("Resolved www.ocelot.com/api/v2 to ", match, " instead of exact")
+	}
+}
+
+func TestResolveRoutePatterns(t *testing.T) {
+	routes := map[string]types.Route{
+		"users":  {ID: "users", ProxiedURL: "api.ocelot.com/users"},
+		"orders": {ID: "orders", ProxiedURL: "api.ocelot.com/users/{id}/orders", PatternType: types.PatternTemplate},
+		"me":     {ID: "me", ProxiedURL: "api.ocelot.com/users/me/orders"},
+		"items":  {ID: "items", ProxiedURL: `api.ocelot.com/items/(?P<sku>[0-9]+)$`, PatternType: types.PatternRegex},
+		"files":  {ID: "files", ProxiedURL: "{tenant}.ocelot.com/files/{name}.json", PatternType: types.PatternTemplate},
+	}
+	index := NewIndex(routes, 0)
+	cases := []struct {
+		host, url, expected string
+		params              map[string]string
+	}{
+		{"api.ocelot.com", "/users/42/orders", "orders", map[string]string{"id": "42"}},
+		{"api.ocelot.com", "/users/42/orders/7", "orders", map[string]string{"id": "42"}},
+		{"api.ocelot.com", "/users/42/ordersummary", "users", map[string]string{}},
+		{"api.ocelot.com", "/users/me/orders", "me", map[string]string{}},
+		{"api.ocelot.com", "/items/1234", "items", map[string]string{"sku": "1234"}},
+		{"api.ocelot.com", "/items/abc", "", nil},
+		{"acme.ocelot.com", "/files/report.json", "files", map[string]string{"tenant": "acme", "name": "report"}},
+	}
+	for _, c := range cases {
+		match := resolve(c.url, c.host, index)
+		if c.expected == "" {
+			if match != nil {
+				t.Fatal("Resolved ", c.host, c.url, " to ", match.Route.ID, " instead of no route")
+			}
+			continue
+		}
+		if match == nil || match.Route.ID != c.expected {
+			t.Fatal("Resolved ", c.host, c.url, " to ", match, " instead of ", c.expected)
+		}
+		if !reflect.DeepEqual(match.Params, c.params) {
+			t.Fatal("Captured ", match.Params, " for ", c.host, c.url, " instead of ", c.params)
+		}
+	}
+}
+
+func TestMatchExpand(t *testing.T) {
+	match := &Match{Params: map[string]string{"id": "42", "tenant": "acme"}}
+	if expanded := match.Expand("/{tenant}/orders/{id}/{missing}"); expanded != "/acme/orders/42/{missing}" {
+		t.Fatal("Expanded to ", expanded, " instead of /acme/orders/42/{missing}")
+	}
+}
+
+func TestMatchExpandKeepsGroupReferences(t *testing.T) {
+	match := &Match{Params: map[string]string{"id": "42"}}
+	if expanded := match.Expand("/v2/${id}/{id}"); expanded != "/v2/${id}/42" {
+		t.Fatal("Expanded to ", expanded, " instead of /v2/${id}/42")
+	}
+}
+
+func TestMatchRewritePath(t *testing.T) {
+	routes := map[string]types.Route{
+		"billing": {ID: "billing", ProxiedURL: "ocelot.com/billing", Rewrite: &types.Rewrite{StripPrefix: true}},
+		"legacy": {ID: "legacy", ProxiedURL: "ocelot.com/legacy", Rewrite: &types.Rewrite{
+			StripPrefix: true, AddPrefix: "/api/v1",
+		}},
+		"orders": {ID: "orders", ProxiedURL: "ocelot.com/users/{id}/orders", PatternType: types.PatternTemplate, Rewrite: &types.Rewrite{
+			Regex: `^/users/[^/]+/orders`, Replacement: "/orders/by-user/{id}",
+		}},
+		"plain": {ID: "plain", ProxiedURL: "ocelot.com/plain"},
+	}
+	index := NewIndex(routes, 0)
+	cases := []struct {
+		url, path, stripped string
+	}{
+		{"/billing/invoices/1", "/invoices/1", "/billing"},
+		{"/billing", "/", "/billing"},
+		{"/legacy/users", "/api/v1/users", "/legacy"},
+		{"/users/42/orders/7", "/orders/by-user/42/7", ""},
+		{"/users/$0/orders/7", "/orders/by-user/$0/7", ""},
+		{"/plain/path", "/plain/path", ""},
+	}
+	for _, c := range cases {
+		match := resolve(c.url, "ocelot.com", index)
+		if match == nil {
+			t.Fatal("No route resolved for ", c.url)
+		}
+		if path, stripped := match.RewritePath(c.url); path != c.path || stripped != c.stripped {
+			t.Fatal("Rewrote ", c.url, " to ", path, " stripping ", stripped, " instead of ", c.path, " stripping ", c.stripped)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(types.Route{ID: "ok", ProxiedURL: "ocelot.com/users/{id}", PatternType: types.PatternTemplate}); err != nil {
+		t.Fatal("Valid template route failed validation: ", err)
+	}
+	negative, heavy := -1, 1001
+	invalid := []types.Route{
+		{ProxiedURL: "ocelot.com"},
+		{ID: "regex", ProxiedURL: "ocelot.com/(unclosed", PatternType: types.PatternRegex},
+		{ID: "template", ProxiedURL: "ocelot.com/users/{id", PatternType: types.PatternTemplate},
+		{ID: "type", ProxiedURL: "ocelot.com", PatternType: "glob"},
+		{ID: "predicate", ProxiedURL: "ocelot.com", Predicates: []types.Predicate{{Type: "body"}}},
+		{ID: "header", ProxiedURL: "ocelot.com", Predicates: []types.Predicate{{Type: types.PredicateHeader}}},
+		{ID: "method", ProxiedURL: "ocelot.com", Predicates: []types.Predicate{{Type: types.PredicateMethod}}},
+		{ID: "rewrite", ProxiedURL: "ocelot.com", Rewrite: &types.Rewrite{Regex: "(unclosed"}},
+		{ID: "scheme", ProxiedURL: "ocelot.com", Upstream: "ftp://files.ocelot.com"},
+		{ID: "host", ProxiedURL: "ocelot.com", Upstream: "http:///path"},
+		{ID: "negative", ProxiedURL: "ocelot.com", Targets: []types.Target{{URL: "http://orders.ocelot.com", Weight: &negative}}},
+		{ID: "heavy", ProxiedURL: "ocelot.com", Targets: []types.Target{{URL: "http://orders.ocelot.com", Weight: &heavy}}},
+		{ID: "cache", ProxiedURL: "ocelot.com", Cache: &types.ResponseCache{Store: "disk"}},
+		{ID: "compression", ProxiedURL: "ocelot.com", Compression: &types.Compression{Encodings: []string{"deflate"}}},
+		{ID: "ratelimit", ProxiedURL: "ocelot.com", RateLimit: &types.RateLimit{Requests: 10, KeyOn: types.HashOnHeader}},
+		{ID: "jwt", ProxiedURL: "ocelot.com", JWT: &types.JWT{Issuers: []string{"https://issuer.ocelot.com"}}},
+		{ID: "jwks", ProxiedURL: "ocelot.com", JWT: &types.JWT{JWKSURL: "https://issuer.ocelot.com/jwks", RefreshInterval: types.Duration(time.Second)}},
+		{ID: "auth", ProxiedURL: "ocelot.com", Auth: &types.Auth{Type: "digest"}},
+		{ID: "forwardauth", ProxiedURL: "ocelot.com", ForwardAuth: &types.ForwardAuth{URL: "auth.ocelot.com/check"}},
+		{ID: "mtls", ProxiedURL: "ocelot.com", TLS: &types.UpstreamTLS{CertFile: "client.pem"}},
+		{ID: "ca", ProxiedURL: "ocelot.com", TLS: &types.UpstreamTLS{CAFile: "missing-ca.pem"}},
+		{ID: "keypair", ProxiedURL: "ocelot.com", TLS: &types.UpstreamTLS{CertFile: "missing-client.pem", KeyFile: "missing-client.key"}},
+		{ID: "h2c", ProxiedURL: "ocelot.com", Upstream: "http://grpc:50051", Protocol: types.ProtocolH2C, Timeouts: &types.Timeouts{
+			ResponseHeader: types.Duration(time.Second),
+		}},
+		{ID: "split", ProxiedURL: "ocelot.com", Split: &types.TrafficSplit{Versions: []types.Version{
+			{Name: "stable", Percent: 90, Upstream: "http://stable:8080"},
+			{Name: "canary", Percent: 20, Upstream: "http://canary:8080"},
+		}}},
+		{ID: "sticky", ProxiedURL: "ocelot.com", Split: &types.TrafficSplit{StickyOn: types.HashOnCookie, Versions: []types.Version{
+			{Name: "stable", Percent: 90, Upstream: "http://stable:8080"},
+			{Name: "canary", Percent: 10, Upstream: "http://canary:8080"},
+		}}},
+	}
+	for _, route := range invalid {
+		if err := Validate(route); err == nil {
+			t.Fatal("Invalid route ", route, " passed validation")
+		}
+	}
+}
+
+func TestResolveRoutePredicates(t *testing.T) {
+	routes := map[string]types.Route{
+		"api": {ID: "api", ProxiedURL: "api.ocelot.com/orders"},
+		"v2": {ID: "v2", ProxiedURL: "api.ocelot.com/orders", Predicates: []types.Predicate{
+			{Type: types.PredicateHeader, Name: "X-Api-Version", Value: "2"},
+		}},
+		"writes": {ID: "writes", ProxiedURL: "api.ocelot.com/orders", Predicates: []types.Predicate{
+			{Type: types.PredicateMethod, Value: "POST, PUT"},
+		}},
+		"beta": {ID: "beta", ProxiedURL: "api.ocelot.com/orders", Priority: 10, Predicates: []types.Predicate{
+			{Type: types.PredicateCookie, Name: "beta"},
+		}},
+		"search": {ID: "search", ProxiedURL: "api.ocelot.com/orders/search", Predicates: []types.Predicate{
+			{Type: types.PredicateQuery, Name: "q", Regex: "^[a-z]+$"},
+		}},
+	}
+	index := NewIndex(routes, 0)
+
+	req := newRequest("GET", "/orders", "api.ocelot.com")
+	if match := ResolveRoute(req, index); match == nil || match.Route.ID != "api" {
+		t.Fatal("Resolved plain GET to ", match, " instead of api")
+	}
+	req.Header.Set("X-Api-Version", "2")
+	if match := ResolveRoute(req, index); match == nil || match.Route.ID != "v2" {
+		t.Fatal("Resolved versioned GET to ", match, " instead of v2")
+	}
+	req.AddCookie(&http.Cookie{Name: "beta", Value: "yes"})
+	if match := ResolveRoute(req, index); match == nil || match.Route.ID != "beta" {
+		t.Fatal("Resolved beta GET to ", match, " instead of beta")
+	}
+	if match := ResolveRoute(newRequest("POST", "/orders", "api.ocelot.com"), index); match == nil || match.Route.ID != "writes" {
+		t.Fatal("Resolved POST to ", match, " instead of writes")
+	}
+	if match := ResolveRoute(newRequest("GET", "/orders/search?q=shoes", "api.ocelot.com"), index); match == nil || match.Route.ID != "search" {
+		t.Fatal("Resolved search to ", match, " instead of search")
+	}
+	// predicates that fail fall back to shorter paths
+	if match := ResolveRoute(newRequest("GET", "/orders/search?q=42", "api.ocelot.com"), index); match == nil || match.Route.ID != "api" {
+		t.Fatal("Resolved numeric search to ", match, " instead of api")
+	}
+}
+
+func benchmarkRoutes(count int) *Index {
+	routes := make(map[string]types.Route)
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("service%d", i)
+		routes[id] = types.Route{ID: id, ProxiedURL: fmt.Sprintf("service%d.ocelot.com/api/v1/service%d", i, i)}
+	}
+	return NewIndex(routes, 0)
+}
+
+func benchmarkResolveRoute(b *testing.B, count int) {
+	index := benchmarkRoutes(count)
+	host := fmt.Sprintf("service%d.ocelot.com", count/2)
+	url := fmt.Sprintf("/api/v1/service%d/orders", count/2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if match := resolve(url, host, index); match == nil {
+			b.Fatal("No route resolved for ", host, url)
+		}
+	}
+}
+
+func BenchmarkResolveRoute10(b *testing.B)    { benchmarkResolveRoute(b, 10) }
+func BenchmarkResolveRoute100(b *testing.B)   { benchmarkResolveRoute(b, 100) }
+func BenchmarkResolveRoute1000(b *testing.B)  { benchmarkResolveRoute(b, 1000) }
+func BenchmarkResolveRoute10000(b *testing.B) { benchmarkResolveRoute(b, 10000) }
+
+func benchmarkResolveRoutePathLength(b *testing.B, segments int) {
+	index := benchmarkRoutes(1000)
+	url := "/api/v1/service500"
+	for i := 0; i < segments; i++ {
+		url = fmt.Sprintf("%s/segment%d", url, i)
+	}
+	req := newRequest("GET", url, "service500.ocelot.com")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ResolveRoute(req, index)
+	}
+}
+
+func BenchmarkResolveRoutePath1(b *testing.B)  { benchmarkResolveRoutePathLength(b, 1) }
+func BenchmarkResolveRoutePath8(b *testing.B)  { benchmarkResolveRoutePathLength(b, 8) }
+func BenchmarkResolveRoutePath64(b *testing.B) { benchmarkResolveRoutePathLength(b, 64) }
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"fmt"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+func validateCache(route *types.Route) error {
+	if cache := route.Cache; cache != nil && cache.Store != "" && cache.Store != types.CacheStoreMemory && cache.Store != types.CacheStoreRedis {
+		return fmt.Errorf("Unknown cache store %s", cache.Store)
+	}
+	return nil
+}
+
+func validateCompression(route *types.Route) error {
+	compression := route.Compression
+	if compression == nil {
+		return nil
+	}
+	for _, encoding := range compression.Encodings {
+		if encoding != types.EncodingBrotli && encoding != types.EncodingGzip {
+			return fmt.Errorf("Unknown compression encoding %s", encoding)
+		}
+	}
+	if compression.Level < 0 || compression.Level > 9 {
+		return fmt.Errorf("Compression level must be between 1 and 9")
+	}
+	return nil
+}
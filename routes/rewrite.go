@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// ForwardedPrefixHeader tells the upstream which prefix was stripped from the path
+const ForwardedPrefixHeader = "X-Forwarded-Prefix"
+
+func compileRewrite(rewrite *types.Rewrite) (*regexp.Regexp, error) {
+	if rewrite == nil || rewrite.Regex == "" {
+		return nil, nil
+	}
+	return regexp.Compile(rewrite.Regex)
+}
+
+// RewritePath applies the route's rewrite options to path. It returns the new
+// path and the prefix that was stripped from it, if any. The matched prefix is
+// stripped first, then the regex replacement is applied and the prefix added.
+// Replacements and added prefixes may reference captured parameters like {id}.
+func (m *Match) RewritePath(path string) (string, string) {
+	rewrite := m.Route.Rewrite
+	if rewrite == nil {
+		return path, ""
+	}
+	stripped := ""
+	if rewrite.StripPrefix && m.Prefix != "" && strings.HasPrefix(path, m.Prefix) {
+		stripped, path = m.Prefix, path[len(m.Prefix):]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if m.compiled != nil && m.compiled.rewrite != nil {
+		// parameters come from the client, so values like $1 must not expand as group references
+		replacement := m.expand(rewrite.Replacement, func(value string) string {
+			return strings.Replace(value, "$", "$$", -1)
+		})
+		path = m.compiled.rewrite.ReplaceAllString(path, replacement)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if rewrite.AddPrefix != "" {
+		path = strings.TrimRight(m.Expand(rewrite.AddPrefix), "/") + path
+	}
+	return path, stripped
+}
@@ -19,29 +19,47 @@ import (
 // Repository contains the routes for the proxy
 type Repository interface {
 	Routes() map[string]types.Route
+	Index() *Index
 	DeleteRoute(id string) (int, error)
 	UpdateRoute(route types.Route)
+	Credentials() map[string]types.Credential
+	DeleteCredential(id string) (int, error)
+	UpdateCredential(credential types.Credential) error
 	Start()
 }
 
 type routeWrapper struct {
-	interval    time.Duration
-	cache       cache.Cache
-	routePoller poller.Poller
-	routes      *SafeRoutes
+	interval     time.Duration
+	maxPathDepth int
+	cache        cache.Cache
+	routePoller  poller.Poller
+	routes       *SafeRoutes
 }
 
 // SafeRoutes helps to ensure only one thread is accessing routes via mutex
 type SafeRoutes struct {
-	routes map[string]types.Route
-	mux    sync.Mutex
+	routes      map[string]types.Route
+	index       *Index
+	credentials map[string]types.Credential
+	mux         sync.Mutex
 }
 
-// Routes accessor
+// Routes accessor, returns a copy so callers can iterate while routes are synced
 func (r *routeWrapper) Routes() map[string]types.Route {
 	r.routes.mux.Lock()
 	defer r.routes.mux.Unlock()
-	return r.routes.routes
+	routes := make(map[string]types.Route, len(r.routes.routes))
+	for id, route := range r.routes.routes {
+		routes[id] = route
+	}
+	return routes
+}
+
+// Index accessor, the index is rebuilt whenever the routing table changes
+func (r *routeWrapper) Index() *Index {
+	r.routes.mux.Lock()
+	defer r.routes.mux.Unlock()
+	return r.routes.index
 }
 
 func (r *routeWrapper) syncRoutesFromRedis() {
@@ -63,6 +81,7 @@ func (r *routeWrapper) syncRoutesFromRedis() {
 
 	r.updateRoutingTable(routes...)
 	log.Printf("Updated routes successfully")
+	r.syncCredentialsFromRedis()
 }
 
 // UpdateRoutes is an atomic operation to update the routing table
@@ -72,6 +91,7 @@ func (r *routeWrapper) updateRoutingTable(routes ...types.Route) {
 	for _, route := range routes {
 		r.routes.routes[route.ID] = route
 	}
+	r.routes.index = NewIndex(r.routes.routes, r.maxPathDepth)
 }
 
 // DeleteRoute updates a route both in redis and in memory
@@ -80,6 +100,7 @@ func (r *routeWrapper) DeleteRoute(id string) (int, error) {
 	defer r.routes.mux.Unlock()
 	if _, ok := r.routes.routes[id]; ok {
 		delete(r.routes.routes, id)
+		r.routes.index = NewIndex(r.routes.routes, r.maxPathDepth)
 	} else {
 		return http.StatusNotFound, fmt.Errorf("Route not found for %s", id)
 	}
@@ -109,9 +130,10 @@ func (r *routeWrapper) UpdateRoute(route types.Route) {
 func (r *routeWrapper) updateRoutesFromDocker() {
 	log.Print("Updating routes")
 	dockerRoutes := r.routePoller.Load()
+	current := r.Routes()
 	for _, dockerRoute := range dockerRoutes {
 		// If route in memory doesn't exist, update redis, then add to memory
-		if r.routes.routes[dockerRoute.ID].ID == "" {
+		if current[dockerRoute.ID].ID == "" {
 			r.UpdateRoute(dockerRoute)
 		}
 	}
@@ -121,8 +143,9 @@ func (r *routeWrapper) updateRoutesFromDocker() {
 func (r *routeWrapper) Start() {
 	// This thread will continually get the routes from the cache and make them available
 	go func() {
-		r.syncRoutesFromRedis()
 		for {
+			// changes published while unsubscribed are picked up by syncing again
+			r.syncRoutesFromRedis()
 			err := r.cache.Subscribe("go-ocelot", r.syncRoutesFromRedis)
 			log.Printf("Subscription to updates lost, retrying in 10 seconds: %v", err)
 			time.Sleep(10 * time.Second)
@@ -135,16 +158,20 @@ func (r *routeWrapper) Start() {
 
 		for range time.Tick(r.interval * time.Second) {
 			r.updateRoutesFromDocker()
+			// revoked credentials must stop working even if an update was missed
+			r.syncCredentialsFromRedis()
 		}
 	}()
 }
 
-// New returns a new instance of the synchronizer
-func New(interval int, redis string) Repository {
+// New returns a new instance of the synchronizer, maxPathDepth limits how many
+// path segments are considered when resolving routes (0 for no limit)
+func New(interval int, redis string, maxPathDepth int) Repository {
 	return Repository(&routeWrapper{
-		interval:    time.Duration(interval),
-		cache:       cache.New(redis),
-		routePoller: poller.New(),
-		routes:      &SafeRoutes{routes: make(map[string]types.Route), mux: sync.Mutex{}},
+		interval:     time.Duration(interval),
+		maxPathDepth: maxPathDepth,
+		cache:        cache.New(redis),
+		routePoller:  poller.New(),
+		routes:       &SafeRoutes{routes: make(map[string]types.Route), index: NewIndex(nil, maxPathDepth), mux: sync.Mutex{}},
 	})
 }
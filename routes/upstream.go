@@ -0,0 +1,228 @@
+package routes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/ocelotconsulting/go-ocelot/types"
+)
+
+// maxTargetWeight bounds the ring entries consistent hashing creates for a target, one set per unit of weight
+const maxTargetWeight = 1000
+
+// Targets returns the upstream targets requests for route are balanced over.
+// Routes listing Targets use those, otherwise the single Upstream is the only
+// target. Routes without either are Docker services, reached by their service
+// name and target port on the swarm network.
+func Targets(route *types.Route) []types.Target {
+	if route.Split != nil {
+		var targets []types.Target
+		for i := range route.Split.Versions {
+			targets = append(targets, VersionTargets(&route.Split.Versions[i])...)
+		}
+		return targets
+	}
+	if len(route.Targets) > 0 {
+		return route.Targets
+	}
+	if route.Upstream != "" {
+		return []types.Target{{URL: route.Upstream}}
+	}
+	return []types.Target{{URL: fmt.Sprintf("http://%s:%d", route.ID, route.TargetPort)}}
+}
+
+// VersionTargets returns the upstream targets of one version of a traffic split
+func VersionTargets(version *types.Version) []types.Target {
+	if len(version.Targets) > 0 {
+		return version.Targets
+	}
+	if version.Upstream != "" {
+		return []types.Target{{URL: version.Upstream}}
+	}
+	return nil
+}
+
+// ParseUpstream parses the URL of an upstream target and checks it can be proxied to
+func ParseUpstream(raw string) (*url.URL, error) {
+	upstream, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if upstream.Scheme != "http" && upstream.Scheme != "https" {
+		return nil, fmt.Errorf("Upstream %s must use http or https", raw)
+	}
+	if upstream.Host == "" {
+		return nil, fmt.Errorf("Upstream %s is missing a host", raw)
+	}
+	return upstream, nil
+}
+
+// protocolScheme returns the scheme upstreams must use to speak a route's protocol, empty for any
+func protocolScheme(protocol string) (string, error) {
+	switch protocol {
+	case "", types.ProtocolHTTP:
+		return "", nil
+	case types.ProtocolH2:
+		return "https", nil
+	case types.ProtocolH2C:
+		return "http", nil
+	}
+	return "", fmt.Errorf("Unknown protocol %s", protocol)
+}
+
+func validateProtocol(route *types.Route) error {
+	scheme, err := protocolScheme(route.Protocol)
+	if err != nil {
+		return err
+	}
+	// HTTP/2 connections are not bounded by these timeouts
+	if timeouts := route.Timeouts; scheme != "" && timeouts != nil && (timeouts.TLSHandshake > 0 || timeouts.ResponseHeader > 0) {
+		return fmt.Errorf("Protocol %s does not support tlsHandshake and responseHeader timeouts", route.Protocol)
+	}
+	return nil
+}
+
+func validateTargets(route *types.Route) error {
+	scheme, err := protocolScheme(route.Protocol)
+	if err != nil {
+		return err
+	}
+	for _, target := range Targets(route) {
+		upstream, err := ParseUpstream(target.URL)
+		if err != nil {
+			return err
+		}
+		if scheme != "" && upstream.Scheme != scheme {
+			return fmt.Errorf("Upstream %s must use %s to speak %s", target.URL, scheme, route.Protocol)
+		}
+		if target.Weight != nil && (*target.Weight < 0 || *target.Weight > maxTargetWeight) {
+			return fmt.Errorf("Upstream %s must have a weight between 0 and %d", target.URL, maxTargetWeight)
+		}
+	}
+	return nil
+}
+
+func validateLoadBalancing(route *types.Route) error {
+	if lb := route.LoadBalancing; lb != nil && lb.Strategy == types.BalanceConsistentHash {
+		switch lb.HashOn {
+		case types.HashOnIP:
+		case types.HashOnHeader, types.HashOnCookie:
+			if lb.HashKey == "" {
+				return fmt.Errorf("Hashing on a %s needs a hashKey", lb.HashOn)
+			}
+		default:
+			return fmt.Errorf("Unknown hashOn %s", lb.HashOn)
+		}
+	}
+	return nil
+}
+
+func validateMirror(route *types.Route) error {
+	mirror := route.Mirror
+	if mirror == nil {
+		return nil
+	}
+	if _, err := ParseUpstream(mirror.Upstream); err != nil {
+		return err
+	}
+	if mirror.Percent != nil && (*mirror.Percent < 0 || *mirror.Percent > 100) {
+		return fmt.Errorf("Mirror percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func validateTLS(route *types.Route) error {
+	config := route.TLS
+	if config == nil {
+		return nil
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return fmt.Errorf("Client certificates need both a certFile and a keyFile")
+	}
+	_, err := TLSConfig(config)
+	return err
+}
+
+func validateRetry(route *types.Route) error {
+	retry := route.Retry
+	if retry == nil {
+		return nil
+	}
+	for _, condition := range retry.RetryOn {
+		switch condition {
+		case types.RetryConnectFailure, types.RetryTimeout, types.Retry5xx:
+		default:
+			return fmt.Errorf("Unknown retry condition %s", condition)
+		}
+	}
+	if retry.Attempts < 0 || retry.Budget < 0 || retry.Budget > 100 {
+		return fmt.Errorf("Retry attempts must not be negative and budget must be a percentage")
+	}
+	return nil
+}
+
+// TLSConfig loads the CA bundle and client certificate a route's upstreams are reached with
+func TLSConfig(config *types.UpstreamTLS) (*tls.Config, error) {
+	result := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		result.RootCAs = x509.NewCertPool()
+		if !result.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", config.CAFile)
+		}
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	return result, nil
+}
+
+func validateSplit(route *types.Route) error {
+	split := route.Split
+	if split == nil {
+		return nil
+	}
+	if len(split.Versions) < 2 {
+		return fmt.Errorf("Traffic splits need at least two versions")
+	}
+	names, total := make(map[string]bool), 0
+	for i, version := range split.Versions {
+		if version.Name == "" || names[version.Name] {
+			return fmt.Errorf("Versions need unique names")
+		}
+		names[version.Name] = true
+		if version.Percent < 0 {
+			return fmt.Errorf("Version %s has a negative percentage", version.Name)
+		}
+		total += version.Percent
+		if len(VersionTargets(&split.Versions[i])) == 0 {
+			return fmt.Errorf("Version %s has no upstream", version.Name)
+		}
+	}
+	if total != 100 {
+		return fmt.Errorf("Version percentages add up to %d instead of 100", total)
+	}
+	switch split.StickyOn {
+	case "":
+	case types.HashOnHeader, types.HashOnCookie:
+		if split.StickyKey == "" {
+			return fmt.Errorf("Sticky %s assignment needs a stickyKey", split.StickyOn)
+		}
+	default:
+		return fmt.Errorf("Unknown stickyOn %s", split.StickyOn)
+	}
+	return nil
+}
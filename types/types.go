@@ -1,9 +1,369 @@
 package types
 
-// Route is the stored route for a proxied service
+import "time"
+
+// Pattern types control how the path of a route's ProxiedURL is matched
+const (
+	// PatternPrefix matches request paths starting with the route path, this is the default
+	PatternPrefix = "prefix"
+	// PatternTemplate matches paths like /users/{id}/orders, capturing each {param} segment
+	PatternTemplate = "template"
+	// PatternRegex matches paths against a regular expression anchored at the start of the path,
+	// named groups like (?P<id>[0-9]+) are captured as parameters
+	PatternRegex = "regex"
+)
+
+// Predicate types select which part of a request a predicate looks at
+const (
+	PredicateMethod = "method"
+	PredicateHeader = "header"
+	PredicateQuery  = "query"
+	PredicateCookie = "cookie"
+)
+
+// Predicate is an extra condition a request must meet for a route to match, checked after its host and path.
+// Method predicates take a comma separated list of methods in Value. Header, query and cookie predicates
+// compare the named value to Value, or to Regex when set, and only require it to be present when neither is.
+type Predicate struct {
+	Type  string `json:"type"`
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	Regex string `json:"regex,omitempty"`
+}
+
+// Rewrite changes the request path before it is forwarded upstream. The path matched by the route is
+// stripped first, then the Regex replacement is applied and finally AddPrefix is added in front.
+type Rewrite struct {
+	StripPrefix bool   `json:"stripPrefix,omitempty"`
+	AddPrefix   string `json:"addPrefix,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// Load balancing strategies choose which target of a route receives a request
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceWeighted         = "weighted"
+	BalanceLeastConnections = "least-connections"
+	BalanceRandomTwo        = "random-two-choices"
+	BalanceConsistentHash   = "consistent-hash"
+)
+
+// Consistent hashing keys requests on one of these
+const (
+	HashOnHeader = "header"
+	HashOnCookie = "cookie"
+	HashOnIP     = "ip"
+	// HashOnQuery keys rate limits on a query parameter, such as an API key
+	HashOnQuery = "query"
+)
+
+// Upstream protocols a route may speak to its targets
+const (
+	// ProtocolHTTP uses HTTP/1.1, or HTTP/2 when an https upstream offers it, this is the default
+	ProtocolHTTP = "http"
+	// ProtocolH2 requires HTTP/2 over TLS, for https upstreams
+	ProtocolH2 = "h2"
+	// ProtocolH2C speaks HTTP/2 without TLS, for http upstreams such as gRPC services
+	ProtocolH2C = "h2c"
+)
+
+// Encodings responses may be compressed with
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// Kinds of credentials protecting routes
+const (
+	// AuthAPIKey credentials are keys of the form <id>.<secret>
+	AuthAPIKey = "apiKey"
+	// AuthBasic credentials are a username, the credential's ID, and password
+	AuthBasic = "basic"
+)
+
+// Stores a route's cached responses may be kept in
+const (
+	// CacheStoreMemory keeps responses in a least recently used cache local to each proxy, this is the default
+	CacheStoreMemory = "memory"
+	// CacheStoreRedis shares responses between proxies through redis
+	CacheStoreRedis = "redis"
+)
+
+// Circuit breaker states
+const (
+	// BreakerClosed lets every request through while counting failures
+	BreakerClosed = "closed"
+	// BreakerOpen rejects every request until OpenTimeout has passed
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets HalfOpenRequests through to decide whether to close or open again
+	BreakerHalfOpen = "half-open"
+)
+
+// Target is one of the upstreams a route balances requests over, URL is like Route.Upstream
+// and Weight, at most 1000, defaults to 1. Targets weighted 0 are drained and receive no requests.
+type Target struct {
+	URL    string `json:"url"`
+	Weight *int   `json:"weight,omitempty"`
+}
+
+// Version is one version of a route's upstream in a traffic split, receiving Percent of the requests. Its
+// targets are given like the route's, by Upstream or Targets.
+type Version struct {
+	Name     string   `json:"name"`
+	Percent  int      `json:"percent"`
+	Upstream string   `json:"upstream,omitempty"`
+	Targets  []Target `json:"targets,omitempty"`
+}
+
+// TrafficSplit divides a route's requests between versions whose percentages add up to 100. Requests are
+// assigned at random unless StickyOn is a header or cookie, named by StickyKey, whose value keeps a user on
+// one version. Sticky cookies missing from a request are issued by the proxy.
+type TrafficSplit struct {
+	Versions  []Version `json:"versions"`
+	StickyOn  string    `json:"stickyOn,omitempty"`
+	StickyKey string    `json:"stickyKey,omitempty"`
+}
+
+// LoadBalancing selects how requests are spread over a route's targets, round robin by default.
+// Consistent hashing uses the client IP or the header or cookie named by HashKey.
+type LoadBalancing struct {
+	Strategy string `json:"strategy,omitempty"`
+	HashOn   string `json:"hashOn,omitempty"`
+	HashKey  string `json:"hashKey,omitempty"`
+}
+
+// HealthCheck actively probes each target of a route with a GET to Path every Interval. A target is marked
+// unhealthy after UnhealthyThreshold failed probes in a row and healthy again after HealthyThreshold
+// successful ones, a probe succeeds when it answers within Timeout with a 2xx or 3xx status.
+type HealthCheck struct {
+	Path               string   `json:"path"`
+	Interval           Duration `json:"interval,omitempty"`
+	Timeout            Duration `json:"timeout,omitempty"`
+	HealthyThreshold   int      `json:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int      `json:"unhealthyThreshold,omitempty"`
+}
+
+// OutlierDetection passively ejects targets based on the responses to real requests. A target is ejected
+// after ConsecutiveErrors connection errors or timeouts in a row, or when at least MinRequests were sent to
+// it within Interval and ErrorRatio of them failed or returned a 5xx status. The first ejection lasts
+// BaseEjectionTime and each following one twice as long as the last, up to MaxEjectionTime.
+type OutlierDetection struct {
+	ConsecutiveErrors int      `json:"consecutiveErrors,omitempty"`
+	ErrorRatio        float64  `json:"errorRatio,omitempty"`
+	MinRequests       int      `json:"minRequests,omitempty"`
+	Interval          Duration `json:"interval,omitempty"`
+	BaseEjectionTime  Duration `json:"baseEjectionTime,omitempty"`
+	MaxEjectionTime   Duration `json:"maxEjectionTime,omitempty"`
+}
+
+// Conditions a RetryPolicy retries on
+const (
+	// RetryConnectFailure retries requests that could not reach the upstream, whatever their method
+	RetryConnectFailure = "connect-failure"
+	// RetryTimeout retries idempotent requests that timed out waiting for the upstream
+	RetryTimeout = "timeout"
+	// Retry5xx retries idempotent requests answered with any 5xx status
+	Retry5xx = "5xx"
+)
+
+// RetryPolicy retries failed requests on another target of the route when there is one. A request is tried
+// at most Attempts times (default 2) when it fails with one of the RetryOn conditions (default connect-failure)
+// or, for idempotent methods, one of StatusCodes. Retries wait a random time up to Backoff doubled for each
+// attempt, capped at MaxBackoff, and stop once they exceed Budget percent of the route's traffic.
+type RetryPolicy struct {
+	Attempts    int      `json:"attempts,omitempty"`
+	RetryOn     []string `json:"retryOn,omitempty"`
+	StatusCodes []int    `json:"statusCodes,omitempty"`
+	Backoff     Duration `json:"backoff,omitempty"`
+	MaxBackoff  Duration `json:"maxBackoff,omitempty"`
+	Budget      float64  `json:"budget,omitempty"`
+}
+
+// UpstreamTLS configures connections to https targets. CAFile is a PEM bundle trusted instead of the system
+// roots, ServerName overrides the name sent for SNI and verified against the certificate, and CertFile and
+// KeyFile are the client certificate presented for mutual TLS. InsecureSkipVerify disables verification and
+// is only meant for development.
+type UpstreamTLS struct {
+	CAFile             string `json:"caFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// StickySession pins each client to one target of the route with a cookie named CookieName
+// (default ocelot-affinity) holding a signature of the target. The cookie lasts TTL, or the browser session when unset.
+// Clients whose target is unavailable or gone are balanced again and given a new cookie.
+type StickySession struct {
+	CookieName string   `json:"cookieName,omitempty"`
+	TTL        Duration `json:"ttl,omitempty"`
+}
+
+// Mirror sends a copy of Percent of a route's requests (default 100, 0 pauses mirroring) to a shadow Upstream
+// and discards its responses. Requests with bodies larger than MaxBodySize bytes (default 64KB) are not
+// mirrored, and shadow requests are abandoned after Timeout (default 10s). Requests are not mirrored while
+// MaxInFlight shadow requests (default 100) are pending.
+type Mirror struct {
+	Upstream    string   `json:"upstream"`
+	Percent     *int     `json:"percent,omitempty"`
+	MaxBodySize int64    `json:"maxBodySize,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	MaxInFlight int      `json:"maxInFlight,omitempty"`
+}
+
+// WebSocket limits a route's WebSocket connections. Connections without traffic in either direction for
+// IdleTimeout are closed, and upgrades beyond MaxConnections open at once are refused.
+type WebSocket struct {
+	IdleTimeout    Duration `json:"idleTimeout,omitempty"`
+	MaxConnections int      `json:"maxConnections,omitempty"`
+}
+
+// RateLimit allows each client Requests per Window (default 1m) over a sliding window shared by every proxy.
+// Clients are told apart by KeyOn: their ip (the default), or the header, cookie or query parameter named Key.
+type RateLimit struct {
+	Requests int      `json:"requests"`
+	Window   Duration `json:"window,omitempty"`
+	KeyOn    string   `json:"keyOn,omitempty"`
+	Key      string   `json:"key,omitempty"`
+}
+
+// Auth requires requests to present a credential of Type. API keys are read from the Header (default
+// X-API-Key) or, when set, the Query parameter. Realm is announced to clients of basic auth.
+type Auth struct {
+	Type   string `json:"type"`
+	Header string `json:"header,omitempty"`
+	Query  string `json:"query,omitempty"`
+	Realm  string `json:"realm,omitempty"`
+}
+
+// Credential is an API key or basic auth user whose secret is stored as a bcrypt Hash. It grants access to
+// the routes listed in Routes, or to every route requiring its Type when there are none.
+type Credential struct {
+	ID     string   `json:"id"`
+	Type   string   `json:"type"`
+	Hash   string   `json:"hash,omitempty"`
+	Routes []string `json:"routes,omitempty"`
+}
+
+// JWT requires requests to carry a bearer token signed by one of the keys in KeysFile, a JWKS or PEM file, or
+// published at JWKSURL, which is fetched again every RefreshInterval (default 10m, at least 1m) and when a token
+// names an unknown key. Tokens must expire and be issued by one of Issuers and for one of Audiences, when given, and have the values
+// of Claims. ForwardClaims maps claims to the headers passing them to the upstream.
+type JWT struct {
+	Issuers         []string          `json:"issuers,omitempty"`
+	Audiences       []string          `json:"audiences,omitempty"`
+	KeysFile        string            `json:"keysFile,omitempty"`
+	JWKSURL         string            `json:"jwksURL,omitempty"`
+	RefreshInterval Duration          `json:"refreshInterval,omitempty"`
+	Claims          map[string]string `json:"claims,omitempty"`
+	ForwardClaims   map[string]string `json:"forwardClaims,omitempty"`
+}
+
+// ForwardAuth asks the service at URL whether to allow each request, by sending it the request's method, URI
+// and headers within Timeout (default 5s). Requests are allowed when it answers 2xx, with the response headers
+// listed in ResponseHeaders copied onto the upstream request, and otherwise the client gets its response.
+type ForwardAuth struct {
+	URL             string   `json:"url"`
+	Timeout         Duration `json:"timeout,omitempty"`
+	ResponseHeaders []string `json:"responseHeaders,omitempty"`
+}
+
+// Compression compresses responses with gzip or brotli, for clients that accept them, when their Content-Type
+// matches ContentTypes and they are at least MinSize bytes. Responses of unknown length, such as streams, are
+// always compressed. Encodings lists the encodings to offer in order of preference, brotli first by default.
+// Level from 1 to 9 trades speed for size, the encoders' defaults are used when it is 0.
+type Compression struct {
+	MinSize      int      `json:"minSize,omitempty"`
+	ContentTypes []string `json:"contentTypes,omitempty"`
+	Encodings    []string `json:"encodings,omitempty"`
+	Level        int      `json:"level,omitempty"`
+}
+
+// ResponseCache caches the responses to GET requests in Store, following Cache-Control, Expires, Vary and
+// ETag. Responses without explicit freshness are cached for DefaultTTL, when set. Bodies larger than
+// MaxBodySize bytes (default 1MB) are not cached.
+type ResponseCache struct {
+	Store       string   `json:"store,omitempty"`
+	DefaultTTL  Duration `json:"defaultTTL,omitempty"`
+	MaxBodySize int64    `json:"maxBodySize,omitempty"`
+}
+
+// Timeouts bound how long a route waits on its upstream. Dial limits connecting to a target, TLSHandshake
+// the handshake with https targets and ResponseHeader the wait for the response headers once the request is
+// sent. Request is the deadline for the whole request including retries, except for WebSocket connections.
+// Unset timeouts keep the defaults of Go's http.DefaultTransport and requests without a deadline.
+type Timeouts struct {
+	Dial           Duration `json:"dial,omitempty"`
+	TLSHandshake   Duration `json:"tlsHandshake,omitempty"`
+	ResponseHeader Duration `json:"responseHeader,omitempty"`
+	Request        Duration `json:"request,omitempty"`
+}
+
+// CircuitBreaker stops forwarding requests to a route after ConsecutiveFailures connection errors or 5xx
+// responses in a row. While open, requests are rejected for OpenTimeout, after which HalfOpenRequests trial
+// requests are let through: the breaker closes if they all succeed and opens again on the first failure.
+type CircuitBreaker struct {
+	ConsecutiveFailures int      `json:"consecutiveFailures,omitempty"`
+	OpenTimeout         Duration `json:"openTimeout,omitempty"`
+	HalfOpenRequests    int      `json:"halfOpenRequests,omitempty"`
+}
+
+// BreakerStatus is the state of a route's circuit breaker as reported by the admin API
+type BreakerStatus struct {
+	State       string    `json:"state"`
+	Since       time.Time `json:"since"`
+	Failures    int       `json:"failures"`
+	Transitions int       `json:"transitions"`
+}
+
+// TargetStatus is the state of one of a route's upstream targets as reported by the admin API
+type TargetStatus struct {
+	URL          string     `json:"url"`
+	Version      string     `json:"version,omitempty"`
+	Weight       int        `json:"weight"`
+	Healthy      bool       `json:"healthy"`
+	Active       int64      `json:"active"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	Ejections    int        `json:"ejections"`
+}
+
+// Route is the stored route for a proxied service. Requests are forwarded to Upstream, a URL with a scheme,
+// host, optional port and optional base path such as https://billing.internal:9443/v1, or balanced over
+// Targets. Routes without either, like those discovered from Docker, are forwarded to the service named ID
+// on TargetPort, and are the only routes that keep the client's Host header unless PreserveHost is set.
 type Route struct {
-	ID          string `json:"id"`
-	TargetPort  int    `json:"targetPort"`
-	Description string `json:"description,omitempty"`
-	ProxiedURL  string `json:"proxiedURL,omitempty"`
+	ID               string            `json:"id"`
+	TargetPort       int               `json:"targetPort"`
+	Description      string            `json:"description,omitempty"`
+	ProxiedURL       string            `json:"proxiedURL,omitempty"`
+	Upstream         string            `json:"upstream,omitempty"`
+	Targets          []Target          `json:"targets,omitempty"`
+	PreserveHost     bool              `json:"preserveHost,omitempty"`
+	Split            *TrafficSplit     `json:"split,omitempty"`
+	Mirror           *Mirror           `json:"mirror,omitempty"`
+	StickySession    *StickySession    `json:"stickySession,omitempty"`
+	WebSocket        *WebSocket        `json:"webSocket,omitempty"`
+	FlushInterval    Duration          `json:"flushInterval,omitempty"`
+	Protocol         string            `json:"protocol,omitempty"`
+	Cache            *ResponseCache    `json:"cache,omitempty"`
+	Compression      *Compression      `json:"compression,omitempty"`
+	RateLimit        *RateLimit        `json:"rateLimit,omitempty"`
+	JWT              *JWT              `json:"jwt,omitempty"`
+	Auth             *Auth             `json:"auth,omitempty"`
+	ForwardAuth      *ForwardAuth      `json:"forwardAuth,omitempty"`
+	LoadBalancing    *LoadBalancing    `json:"loadBalancing,omitempty"`
+	HealthCheck      *HealthCheck      `json:"healthCheck,omitempty"`
+	OutlierDetection *OutlierDetection `json:"outlierDetection,omitempty"`
+	CircuitBreaker   *CircuitBreaker   `json:"circuitBreaker,omitempty"`
+	Retry            *RetryPolicy      `json:"retry,omitempty"`
+	Timeouts         *Timeouts         `json:"timeouts,omitempty"`
+	TLS              *UpstreamTLS      `json:"tls,omitempty"`
+	PatternType      string            `json:"patternType,omitempty"`
+	SubdomainHeader  string            `json:"subdomainHeader,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	Predicates       []Predicate       `json:"predicates,omitempty"`
+	Priority         int               `json:"priority,omitempty"`
+	Rewrite          *Rewrite          `json:"rewrite,omitempty"`
 }